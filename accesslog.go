@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ACCESS LOG
+
+type AccessLogFormat int
+
+const (
+	// AccessLogJSON escreve uma linha JSON por request, para coletores de log
+	AccessLogJSON AccessLogFormat = iota
+	// AccessLogCombined usa o formato combined do Apache/nginx
+	AccessLogCombined
+	// AccessLogDev é um formato curto e colorido para desenvolvimento
+	AccessLogDev
+)
+
+type AccessLogConfig struct {
+	Format AccessLogFormat
+	// Output é onde as linhas são escritas. O padrão é os.Stdout
+	Output io.Writer
+	// SampleRate entre 0 e 1 loga só essa fração das requests com sucesso. Respostas
+	// com status >= 400 são sempre logadas. Zero loga tudo
+	SampleRate float64
+	// Skip define requests que não são logadas, como health checks. Rotas também podem
+	// sair do access log com ExcludeMiddlewares: []string{"access_log"}
+	Skip SkipRule
+}
+
+// AccessLogEntry são os dados de uma request registrados no access log
+type AccessLogEntry struct {
+	Time      time.Time     `json:"time"`
+	Method    string        `json:"method"`
+	Route     string        `json:"route,omitempty"`
+	Path      string        `json:"path"`
+	Proto     string        `json:"proto"`
+	Status    int           `json:"status"`
+	Bytes     int64         `json:"bytes"`
+	Duration  time.Duration `json:"-"`
+	ClientIP  string        `json:"client_ip"`
+	UserAgent string        `json:"user_agent,omitempty"`
+	Referer   string        `json:"referer,omitempty"`
+	RequestID string        `json:"request_id,omitempty"`
+	UserID    string        `json:"user_id,omitempty"`
+}
+
+// AccessLog cria um middleware que registra cada request com o status e o tamanho finais
+// da resposta. Erros retornados pelo handler são escritos pelo servidor depois que o
+// middleware retorna, então o status logado nesse caso vem de errorStatus
+func AccessLog(cfg AccessLogConfig) *NamedMiddleware {
+	if cfg.Output == nil {
+		cfg.Output = os.Stdout
+	}
+
+	var mu sync.Mutex
+	write := func(entry *AccessLogEntry) {
+		var buf bytes.Buffer
+		formatAccessLog(&buf, cfg.Format, entry)
+
+		// uma escrita por linha, para não misturar linhas de requests concorrentes
+		mu.Lock()
+		defer mu.Unlock()
+		cfg.Output.Write(buf.Bytes())
+	}
+
+	return Named("access_log", func(next APIFunc) APIFunc {
+		return func(tc *TupaContext) (err error) {
+			if cfg.Skip.matches(tc, tc.route) {
+				return next(tc)
+			}
+
+			start := time.Now()
+
+			defer func() {
+				status := tc.Writer().Status()
+
+				// o recover do servidor fica por fora e ainda vai responder 500
+				r := recover()
+				switch {
+				case tc.Writer().Committed():
+				case r != nil:
+					status = http.StatusInternalServerError
+				case err != nil:
+					status = errorStatus(err)
+				}
+
+				if r != nil || shouldSampleAccessLog(cfg.SampleRate, status) {
+					write(newAccessLogEntry(tc, start, status))
+				}
+
+				if r != nil {
+					panic(r)
+				}
+			}()
+
+			return next(tc)
+		}
+	}, After("request_id"))
+}
+
+func shouldSampleAccessLog(rate float64, status int) bool {
+	if rate <= 0 || rate >= 1 || status >= http.StatusBadRequest {
+		return true
+	}
+	return rand.Float64() < rate
+}
+
+func newAccessLogEntry(tc *TupaContext, start time.Time, status int) *AccessLogEntry {
+	// sem nada escrito o net/http responde 200 ao final do handler
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	entry := &AccessLogEntry{
+		Time:      start,
+		Method:    tc.request.Method,
+		Path:      tc.request.URL.Path,
+		Proto:     tc.request.Proto,
+		Status:    status,
+		Bytes:     tc.Writer().Size(),
+		Duration:  time.Since(start),
+		ClientIP:  tc.RealIP(),
+		UserAgent: tc.request.UserAgent(),
+		Referer:   tc.request.Referer(),
+		RequestID: tc.requestID,
+		UserID:    tc.userID,
+	}
+	if tc.route != nil {
+		entry.Route = tc.route.Path
+	}
+	return entry
+}
+
+func formatAccessLog(buf *bytes.Buffer, format AccessLogFormat, e *AccessLogEntry) {
+	switch format {
+	case AccessLogCombined:
+		user := e.UserID
+		if user == "" {
+			user = "-"
+		}
+		bytesSent := "-"
+		if e.Bytes > 0 {
+			bytesSent = strconv.FormatInt(e.Bytes, 10)
+		}
+
+		fmt.Fprintf(buf, "%s - %s [%s] %q %d %s %q %q\n",
+			e.ClientIP, user, e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+			e.Method+" "+e.Path+" "+e.Proto, e.Status, bytesSent,
+			orDash(e.Referer), orDash(e.UserAgent))
+
+	case AccessLogDev:
+		status := strconv.Itoa(e.Status)
+		switch {
+		case e.Status >= 500:
+			status = FmtRed(status)
+		case e.Status >= 400:
+			status = FmtYellow(status)
+		default:
+			status = FmtBlue(status)
+		}
+
+		fmt.Fprintf(buf, "%s %s %s %s %dB %s\n",
+			e.Time.Format("15:04:05"), status, e.Method, e.Path, e.Bytes, e.Duration.Round(time.Microsecond))
+
+	default:
+		// duração em milissegundos com casas decimais, mais fácil de agregar que a string do Go
+		line := struct {
+			*AccessLogEntry
+			DurationMS float64 `json:"duration_ms"`
+		}{e, float64(e.Duration) / float64(time.Millisecond)}
+
+		json.NewEncoder(buf).Encode(line)
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
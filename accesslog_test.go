@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	catRoute := RouteInfo{
+		Path:   "/cats/{id}",
+		Method: MethodGet,
+		Handler: APIFunc(func(tc *TupaContext) error {
+			return tc.SendString("miau")
+		}),
+	}
+
+	t.Run("Testando linha JSON", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/cats/7", nil)
+		req.Header.Set("User-Agent", "teste/1.0")
+		req.Header.Set("X-Request-ID", "req-log")
+
+		var out bytes.Buffer
+		serveRoute(catRoute, req, RequestIDMiddleware(RequestIDConfig{}), AccessLog(AccessLogConfig{Output: &out}))
+
+		var entry map[string]any
+		if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+			t.Fatalf("linha JSON inválida %q: %v", out.String(), err)
+		}
+
+		expected := map[string]any{
+			"method":     "GET",
+			"route":      "/cats/{id}",
+			"path":       "/cats/7",
+			"status":     float64(200),
+			"bytes":      float64(4),
+			"user_agent": "teste/1.0",
+			"request_id": "req-log",
+			"client_ip":  "192.0.2.1",
+		}
+		for key, want := range expected {
+			if entry[key] != want {
+				t.Errorf("%s: recebeu %v queria %v", key, entry[key], want)
+			}
+		}
+		if _, ok := entry["duration_ms"]; !ok {
+			t.Errorf("linha sem duration_ms: %s", out.String())
+		}
+	})
+
+	t.Run("Testando status final de erro do handler", func(t *testing.T) {
+		route := RouteInfo{
+			Path:   "/cats/{id}",
+			Method: MethodGet,
+			Handler: APIFunc(func(tc *TupaContext) error {
+				return NotFound("gato não encontrado")
+			}),
+		}
+
+		var out bytes.Buffer
+		w := serveRoute(route, httptest.NewRequest(http.MethodGet, "/cats/8", nil), AccessLog(AccessLogConfig{Format: AccessLogCombined, Output: &out}))
+
+		if w.Code != http.StatusNotFound || strings.Count(w.Body.String(), "gato não encontrado") != 1 {
+			t.Errorf("erro deveria ser escrito uma vez: %d %q", w.Code, w.Body.String())
+		}
+
+		// o servidor escreve o erro depois do access log, então o tamanho ainda não é conhecido
+		combined := regexp.MustCompile(`^192\.0\.2\.1 - - \[[^\]]+\] "GET /cats/8 HTTP/1\.1" 404 - "-" "-"\n$`)
+		if !combined.MatchString(out.String()) {
+			t.Errorf("linha combined inesperada: %q", out.String())
+		}
+	})
+
+	t.Run("Testando panic logado como 500", func(t *testing.T) {
+		route := RouteInfo{
+			Path:   "/panic",
+			Method: MethodGet,
+			Handler: APIFunc(func(tc *TupaContext) error {
+				panic("boom")
+			}),
+		}
+
+		var out bytes.Buffer
+		w := serveRoute(route, httptest.NewRequest(http.MethodGet, "/panic", nil), AccessLog(AccessLogConfig{Format: AccessLogDev, Output: &out}))
+
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("recover deveria responder 500, recebeu %d", w.Code)
+		}
+		if !strings.Contains(out.String(), FmtRed("500")) {
+			t.Errorf("linha dev deveria ter 500 em vermelho: %q", out.String())
+		}
+	})
+
+	t.Run("Testando exclusões e amostragem", func(t *testing.T) {
+		health := RouteInfo{Path: "/health", Method: MethodGet, Handler: APIFunc(handleSendString)}
+		var out bytes.Buffer
+		serveRoute(health, httptest.NewRequest(http.MethodGet, "/health", nil), AccessLog(AccessLogConfig{Skip: SkipRule{Paths: []string{"/health"}}, Output: &out}))
+		if out.Len() != 0 {
+			t.Errorf("health check não deveria ser logado: %q", out.String())
+		}
+
+		health.ExcludeMiddlewares = []string{"access_log"}
+		serveRoute(health, httptest.NewRequest(http.MethodGet, "/health", nil), AccessLog(AccessLogConfig{Output: &out}))
+		if out.Len() != 0 {
+			t.Errorf("rota com ExcludeMiddlewares não deveria ser logada: %q", out.String())
+		}
+
+		if !shouldSampleAccessLog(0.000001, http.StatusInternalServerError) {
+			t.Errorf("erros deveriam ser logados mesmo com amostragem")
+		}
+
+		sampled := 0
+		for i := 0; i < 1000; i++ {
+			if shouldSampleAccessLog(0.1, http.StatusOK) {
+				sampled++
+			}
+		}
+		if sampled == 0 || sampled > 300 {
+			t.Errorf("amostragem de 10%% logou %d de 1000", sampled)
+		}
+	})
+
+	t.Run("Testando que o erro continua visível para middlewares externos", func(t *testing.T) {
+		var seen error
+		cause := errors.New("falha")
+
+		outer := MiddlewareFunc(func(next APIFunc) APIFunc {
+			return func(tc *TupaContext) error {
+				seen = next(tc)
+				return seen
+			}
+		})
+
+		route := RouteInfo{Path: "/erro", Method: MethodGet, Handler: APIFunc(func(tc *TupaContext) error { return cause })}
+		w := serveRoute(route, httptest.NewRequest(http.MethodGet, "/erro", nil), outer, AccessLog(AccessLogConfig{Output: &bytes.Buffer{}}))
+
+		if !errors.Is(seen, cause) || w.Code != http.StatusInternalServerError {
+			t.Errorf("middleware externo deveria ver o erro: %v %d", seen, w.Code)
+		}
+	})
+}
@@ -152,7 +152,7 @@ func AuthGoogleCallback(tc *TupaContext) (*GoogleAuthResponse, error) {
 	// 	return nil, nil
 	// }
 
-	token, err := GoogleOauthConfig.Exchange(context.Background(), code)
+	token, err := GoogleOauthConfig.Exchange(tc, code)
 	if err != nil {
 		fmt.Printf("Exchange do código falhou '%s'\n", err)
 		return nil, err
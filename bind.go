@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BINDING DE PARAMETROS
+
+// BindError indica que um parâmetro da request não pôde ser convertido para o campo da struct
+type BindError struct {
+	Field string
+	Value string
+	Err   error
+}
+
+func (e *BindError) Error() string {
+	if e.Value == "" {
+		return fmt.Sprintf("parâmetro '%s' inválido: %v", e.Field, e.Err)
+	}
+	return fmt.Sprintf("parâmetro '%s' inválido (%q): %v", e.Field, e.Value, e.Err)
+}
+
+func (e *BindError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	timeType           = reflect.TypeOf(time.Time{})
+	durationType       = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerTyp = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// BindQuery preenche a struct apontada por dst com os valores da query string.
+// O nome do parâmetro vem da tag `query` (ou do nome do campo em minúsculas) e valores
+// ausentes usam a tag `default`. Slices aceitam parâmetros repetidos e separados por vírgula
+//
+//	type UserFilter struct {
+//		Name   string    `query:"name"`
+//		Age    int       `query:"idade" default:"18"`
+//		Tags   []string  `query:"tag"`
+//		Since  time.Time `query:"since" layout:"2006-01-02"`
+//	}
+func (tc *TupaContext) BindQuery(dst any) error {
+	return bindValues(tc.request.URL.Query(), dst, "query", bindOptions{defaults: true})
+}
+
+// bindOptions ajustam o bind para quando a struct é preenchida por várias fontes, como em
+// Handle, em que uma fonte não pode desfazer o que outra preencheu
+type bindOptions struct {
+	// defaults usa a tag default nos campos que não vieram em values
+	defaults bool
+	// tagged liga só os campos com a tag da fonte, sem cair no nome do campo em minúsculas
+	tagged bool
+}
+
+func bindValues(values map[string][]string, dst any, tag string, opts bindOptions) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return errors.New("destino do bind precisa ser um ponteiro para struct")
+	}
+
+	return bindStruct(values, v.Elem(), tag, opts)
+}
+
+func bindStruct(values map[string][]string, v reflect.Value, tag string, opts bindOptions) error {
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fieldValue := v.Field(i)
+
+		name, hasTag := field.Tag.Lookup(tag)
+		if name == "-" {
+			continue
+		}
+
+		// structs embutidas sem tag têm seus campos tratados como se fossem do pai
+		if field.Anonymous && !hasTag && field.Type.Kind() == reflect.Struct {
+			if err := bindStruct(values, fieldValue, tag, opts); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !field.IsExported() || (opts.tagged && !hasTag) {
+			continue
+		}
+
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+
+		raw, ok := values[name]
+		if !ok || len(raw) == 0 {
+			def, hasDefault := field.Tag.Lookup("default")
+			if !opts.defaults || !hasDefault {
+				continue
+			}
+			raw = []string{def}
+		}
+
+		if err := setField(fieldValue, field, raw); err != nil {
+			return &BindError{Field: name, Value: strings.Join(raw, ","), Err: err}
+		}
+	}
+
+	return nil
+}
+
+// applyDefaults preenche os campos que têm a tag default. Handle chama antes de ler as
+// fontes da request, então o default só fica nos campos que nenhuma delas preencheu
+func applyDefaults(v reflect.Value) error {
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fieldValue := v.Field(i)
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if err := applyDefaults(fieldValue); err != nil {
+				return err
+			}
+			continue
+		}
+
+		def, ok := field.Tag.Lookup("default")
+		if !ok || !field.IsExported() {
+			continue
+		}
+
+		if err := setField(fieldValue, field, []string{def}); err != nil {
+			return &BindError{Field: field.Name, Value: def, Err: err}
+		}
+	}
+
+	return nil
+}
+
+func setField(v reflect.Value, field reflect.StructField, raw []string) error {
+	if v.Kind() == reflect.Slice && !v.Type().Implements(textUnmarshalerTyp) {
+		var items []string
+		for _, value := range raw {
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+		}
+
+		slice := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setValue(slice.Index(i), field, item); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+	}
+
+	// para campos simples vale o último valor, como em url.Values.Get
+	return setValue(v, field, raw[len(raw)-1])
+}
+
+func setValue(v reflect.Value, field reflect.StructField, raw string) error {
+	if v.Kind() == reflect.Pointer {
+		ptr := reflect.New(v.Type().Elem())
+		if err := setValue(ptr.Elem(), field, raw); err != nil {
+			return err
+		}
+		v.Set(ptr)
+		return nil
+	}
+
+	if v.CanAddr() && v.Addr().Type().Implements(textUnmarshalerTyp) && v.Type() != timeType {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(raw))
+	}
+
+	switch v.Type() {
+	case timeType:
+		layout := field.Tag.Get("layout")
+		if layout == "" {
+			layout = time.RFC3339
+		}
+
+		parsed, err := time.Parse(layout, raw)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(parsed))
+		return nil
+
+	case durationType:
+		parsed, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(parsed))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(parsed)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(raw, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(parsed)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		parsed, err := strconv.ParseUint(raw, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(parsed)
+
+	case reflect.Float32, reflect.Float64:
+		parsed, err := strconv.ParseFloat(raw, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(parsed)
+
+	default:
+		return fmt.Errorf("tipo %s não suportado", v.Type())
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type bindTestFilter struct {
+	Name    string        `query:"name"`
+	Age     int           `query:"idade" default:"18"`
+	Active  *bool         `query:"active"`
+	Tags    []string      `query:"tag"`
+	IDs     []int         `query:"ids"`
+	Since   time.Time     `query:"since" layout:"2006-01-02"`
+	Timeout time.Duration `query:"timeout"`
+	Ignored string        `query:"-"`
+}
+
+func TestBindQuery(t *testing.T) {
+	t.Run("Testando bind de tipos variados", func(t *testing.T) {
+		tc := newTestContext(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users?name=Victor&active=true&tag=a&tag=b,c&ids=1,2&since=2024-02-26&timeout=2s&Ignored=x", nil))
+
+		var filter bindTestFilter
+		if err := tc.BindQuery(&filter); err != nil {
+			t.Fatalf("erro inesperado: %v", err)
+		}
+
+		if filter.Name != "Victor" || filter.Age != 18 || filter.Active == nil || !*filter.Active {
+			t.Errorf("campos simples incorretos: %+v", filter)
+		}
+		if !reflect.DeepEqual(filter.Tags, []string{"a", "b", "c"}) || !reflect.DeepEqual(filter.IDs, []int{1, 2}) {
+			t.Errorf("slices incorretos: %v %v", filter.Tags, filter.IDs)
+		}
+		if !filter.Since.Equal(time.Date(2024, 2, 26, 0, 0, 0, 0, time.UTC)) || filter.Timeout != 2*time.Second {
+			t.Errorf("tempos incorretos: %v %v", filter.Since, filter.Timeout)
+		}
+		if filter.Ignored != "" {
+			t.Errorf("campo com query:\"-\" não deveria ser preenchido")
+		}
+	})
+
+	t.Run("Testando parâmetro inválido", func(t *testing.T) {
+		tc := newTestContext(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users?idade=abc", nil))
+
+		var filter bindTestFilter
+		err := tc.BindQuery(&filter)
+
+		var bindErr *BindError
+		if !errors.As(err, &bindErr) || bindErr.Field != "idade" {
+			t.Errorf("esperava BindError no campo idade, recebeu %v", err)
+		}
+	})
+
+	t.Run("Testando HandleEndpointQueryParams sem parâmetros", func(t *testing.T) {
+		tc := newTestContext(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users", nil))
+
+		if err := HandleEndpointQueryParams(tc); err != nil {
+			t.Fatalf("erro inesperado: %v", err)
+		}
+
+		w := tc.Writer().Unwrap().(*httptest.ResponseRecorder)
+		if got := w.Body.String(); got != "Hello visitante" {
+			t.Errorf("esperava 'Hello visitante', recebeu '%s'", got)
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// BODY DA REQUEST
+
+const DefaultMaxBodySize int64 = 10 << 20
+
+var (
+	ErrBodyTooLarge        = NewHTTPError(http.StatusRequestEntityTooLarge, "body da request excede o tamanho máximo permitido")
+	ErrUnsupportedEncoding = NewHTTPError(http.StatusUnsupportedMediaType, "Content-Encoding da request não suportado")
+)
+
+// SetMaxBodySize define o tamanho máximo do body lido por tc.Body, já descomprimido
+func (a *APIServer) SetMaxBodySize(n int64) {
+	a.maxBodySize = n
+}
+
+// SetBodyLimit troca o limite do body só para esta request, útil em middlewares de rotas de upload
+func (tc *TupaContext) SetBodyLimit(n int64) {
+	tc.bodyLimit = n
+}
+
+func (tc *TupaContext) maxBodySize() int64 {
+	if tc.bodyLimit > 0 {
+		return tc.bodyLimit
+	}
+
+	if tc.server != nil && tc.server.maxBodySize > 0 {
+		return tc.server.maxBodySize
+	}
+
+	return DefaultMaxBodySize
+}
+
+// Body lê o body inteiro da request, descomprimindo gzip e deflate, e guarda os bytes.
+// Chamadas seguintes retornam os mesmos bytes e o Request().Body é reposicionado no início,
+// então um middleware pode ler o body e o handler ainda consegue decodificá-lo.
+// Bodies acima do limite retornam ErrBodyTooLarge (413)
+func (tc *TupaContext) Body() ([]byte, error) {
+	if tc.bodyRead {
+		tc.request.Body = io.NopCloser(bytes.NewReader(tc.body))
+		return tc.body, nil
+	}
+
+	limit := tc.maxBodySize()
+	r := tc.request
+
+	if r.Body == nil || r.Body == http.NoBody {
+		tc.setBody(nil)
+		return nil, nil
+	}
+	defer r.Body.Close()
+
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	if (encoding == "" || encoding == "identity") && r.ContentLength > limit {
+		return nil, ErrBodyTooLarge
+	}
+
+	reader, err := decodeBody(r.Body, encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	// lendo um byte além do limite para saber se ele foi excedido. O limite vale para o
+	// conteúdo descomprimido, o que protege contra bombas de descompressão
+	body, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, ErrBodyTooLarge
+		}
+		return nil, &BindError{Field: "body", Err: err}
+	}
+
+	if int64(len(body)) > limit {
+		return nil, ErrBodyTooLarge
+	}
+
+	if encoding != "" {
+		r.Header.Del("Content-Encoding")
+	}
+
+	tc.setBody(body)
+	return body, nil
+}
+
+func (tc *TupaContext) setBody(body []byte) {
+	tc.body = body
+	tc.bodyRead = true
+	tc.request.Body = io.NopCloser(bytes.NewReader(body))
+	tc.request.ContentLength = int64(len(body))
+	tc.request.Header.Set("Content-Length", strconv.Itoa(len(body)))
+}
+
+func decodeBody(body io.Reader, encoding string) (io.Reader, error) {
+	switch encoding {
+	case "", "identity":
+		return body, nil
+
+	case "gzip", "x-gzip":
+		reader, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, &BindError{Field: "body", Err: fmt.Errorf("gzip inválido: %w", err)}
+		}
+		return reader, nil
+
+	case "deflate":
+		// "deflate" no HTTP é zlib (RFC 1950), mas alguns clientes mandam deflate puro
+		buffered := bufio.NewReader(body)
+		header, err := buffered.Peek(2)
+		if err == nil && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 && header[0]&0x0f == 8 {
+			reader, err := zlib.NewReader(buffered)
+			if err != nil {
+				return nil, &BindError{Field: "body", Err: fmt.Errorf("deflate inválido: %w", err)}
+			}
+			return reader, nil
+		}
+		return flate.NewReader(buffered), nil
+
+	default:
+		return nil, ErrUnsupportedEncoding
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func compressBody(t *testing.T, encoding string, data []byte) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	if encoding == "gzip" {
+		w = gzip.NewWriter(&buf)
+	} else {
+		w = zlib.NewWriter(&buf)
+	}
+
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	return &buf
+}
+
+func TestBody(t *testing.T) {
+	t.Run("Testando body lido mais de uma vez", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`"Victor"`))
+		tc := (&TupaContext{}).NewTupaContext(httptest.NewRecorder(), req)
+
+		first, err := tc.Body()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		second, _ := io.ReadAll(tc.Request().Body)
+		if string(first) != `"Victor"` || string(second) != `"Victor"` {
+			t.Errorf("esperava o body nas duas leituras, recebeu %q e %q", first, second)
+		}
+	})
+
+	for _, encoding := range []string{"gzip", "deflate"} {
+		t.Run("Testando body com Content-Encoding "+encoding, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", compressBody(t, encoding, []byte("comprimido")))
+			req.Header.Set("Content-Encoding", encoding)
+			tc := (&TupaContext{}).NewTupaContext(httptest.NewRecorder(), req)
+
+			body, err := tc.Body()
+			if err != nil || string(body) != "comprimido" {
+				t.Errorf("esperava 'comprimido', recebeu %q (erro %v)", body, err)
+			}
+			if tc.Request().Header.Get("Content-Encoding") != "" {
+				t.Errorf("Content-Encoding deveria ter sido removido após descomprimir")
+			}
+		})
+	}
+
+	t.Run("Testando proteção contra bomba de descompressão", func(t *testing.T) {
+		bomb := compressBody(t, "gzip", bytes.Repeat([]byte{0}, 1<<20))
+		req := httptest.NewRequest(http.MethodPost, "/", bomb)
+		req.Header.Set("Content-Encoding", "gzip")
+		tc := (&TupaContext{}).NewTupaContext(httptest.NewRecorder(), req)
+		tc.SetBodyLimit(64 << 10)
+
+		if bomb.Len() > 64<<10 {
+			t.Fatalf("o body comprimido deveria caber no limite, tem %d bytes", bomb.Len())
+		}
+		if _, err := tc.Body(); err != ErrBodyTooLarge {
+			t.Errorf("esperava ErrBodyTooLarge, recebeu %v", err)
+		}
+	})
+
+	t.Run("Testando 413 pelo dispatcher", func(t *testing.T) {
+		server := NewAPIServer(":8080")
+		server.SetMaxBodySize(4)
+
+		handler := server.MakeHTTPHandlerFuncHelper(RouteInfo{
+			Method: MethodPost,
+			Handler: APIFunc(func(tc *TupaContext) error {
+				_, err := tc.Body()
+				return err
+			}),
+		})
+
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("grande demais")))
+
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("esperava 413, recebeu %d", w.Code)
+		}
+	})
+
+	t.Run("Testando Content-Encoding não suportado", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("br"))
+		req.Header.Set("Content-Encoding", "br")
+		tc := (&TupaContext{}).NewTupaContext(httptest.NewRecorder(), req)
+
+		if _, err := tc.Body(); errorStatus(err) != http.StatusUnsupportedMediaType {
+			t.Errorf("esperava 415, recebeu %v", err)
+		}
+	})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// BULKHEAD
+
+type BulkheadConfig struct {
+	// MaxConcurrent é o máximo de requests executando ao mesmo tempo
+	MaxConcurrent int
+	// MaxQueue é quantas requests podem esperar por uma vaga. Zero rejeita assim que lotar
+	MaxQueue int
+	// QueueTimeout é o tempo máximo de espera na fila. Zero espera enquanto a request existir
+	QueueTimeout time.Duration
+	// RetryAfter é o valor do header nas respostas 503. O padrão é 1s
+	RetryAfter time.Duration
+}
+
+// Bulkhead limita as requests simultâneas de uma rota ou de um grupo de rotas, para que
+// handlers lentos não consumam o processo inteiro. Use o mesmo Bulkhead em várias rotas
+// para que elas dividam as vagas
+//
+//	uploads := NewBulkhead(BulkheadConfig{MaxConcurrent: 4, MaxQueue: 16, QueueTimeout: 2 * time.Second})
+//	AddRoutes(MiddlewareChain{uploads.Middleware()}, ContrUploadImage)
+type Bulkhead struct {
+	cfg      BulkheadConfig
+	slots    chan struct{}
+	queued   atomic.Int64
+	rejected atomic.Int64
+}
+
+// BulkheadStats é a utilização atual do bulkhead, para métricas
+type BulkheadStats struct {
+	InFlight      int
+	Queued        int
+	MaxConcurrent int
+	MaxQueue      int
+	// Rejected é o total de requests rejeitadas desde a criação
+	Rejected int64
+	// Utilization é InFlight / MaxConcurrent, entre 0 e 1
+	Utilization float64
+}
+
+func NewBulkhead(cfg BulkheadConfig) *Bulkhead {
+	if cfg.MaxConcurrent <= 0 {
+		cfg.MaxConcurrent = 1
+	}
+	if cfg.RetryAfter <= 0 {
+		cfg.RetryAfter = time.Second
+	}
+
+	return &Bulkhead{cfg: cfg, slots: make(chan struct{}, cfg.MaxConcurrent)}
+}
+
+func (b *Bulkhead) Stats() BulkheadStats {
+	inFlight := len(b.slots)
+	return BulkheadStats{
+		InFlight:      inFlight,
+		Queued:        int(b.queued.Load()),
+		MaxConcurrent: b.cfg.MaxConcurrent,
+		MaxQueue:      b.cfg.MaxQueue,
+		Rejected:      b.rejected.Load(),
+		Utilization:   float64(inFlight) / float64(b.cfg.MaxConcurrent),
+	}
+}
+
+func (b *Bulkhead) Middleware() *NamedMiddleware {
+	return Named("bulkhead", func(next APIFunc) APIFunc {
+		return func(tc *TupaContext) error {
+			if err := b.acquire(tc); err != nil {
+				return err
+			}
+			// liberando em defer para não perder a vaga num panic
+			defer func() { <-b.slots }()
+
+			return next(tc)
+		}
+	})
+}
+
+func (b *Bulkhead) acquire(tc *TupaContext) error {
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if b.queued.Add(1) > int64(b.cfg.MaxQueue) {
+		b.queued.Add(-1)
+		return b.reject()
+	}
+	defer b.queued.Add(-1)
+
+	var timeout <-chan time.Time
+	if b.cfg.QueueTimeout > 0 {
+		timer := time.NewTimer(b.cfg.QueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	case <-timeout:
+		return b.reject()
+	case <-tc.Done():
+		// o cliente desistiu enquanto esperava
+		return NewHTTPError(http.StatusServiceUnavailable, "").Wrap(tc.Err())
+	}
+}
+
+func (b *Bulkhead) reject() error {
+	b.rejected.Add(1)
+	return NewHTTPError(http.StatusServiceUnavailable, "servidor ocupado, tente novamente em instantes").
+		WithHeader("Retry-After", strconv.Itoa(ceilSeconds(b.cfg.RetryAfter)))
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBulkhead(t *testing.T) {
+	newRoute := func(bulkhead *Bulkhead, release <-chan struct{}, started chan<- struct{}) http.HandlerFunc {
+		server := NewAPIServer(":6969")
+		return server.MakeHTTPHandlerFuncHelper(RouteInfo{
+			Path:        "/lento",
+			Method:      MethodPost,
+			Middlewares: []Middleware{bulkhead.Middleware()},
+			Handler: APIFunc(func(tc *TupaContext) error {
+				started <- struct{}{}
+				<-release
+				return tc.SendString("ok")
+			}),
+		})
+	}
+
+	serveAsync := func(handler http.HandlerFunc, wg *sync.WaitGroup) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler(w, httptest.NewRequest(http.MethodPost, "/lento", nil))
+		}()
+		return w
+	}
+
+	waitFor := func(t *testing.T, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("condição não atingida a tempo")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	t.Run("Testando rejeição sem fila", func(t *testing.T) {
+		bulkhead := NewBulkhead(BulkheadConfig{MaxConcurrent: 1, RetryAfter: 2 * time.Second})
+		release, started := make(chan struct{}), make(chan struct{}, 2)
+		handler := newRoute(bulkhead, release, started)
+
+		var wg sync.WaitGroup
+		first := serveAsync(handler, &wg)
+		<-started
+
+		if stats := bulkhead.Stats(); stats.InFlight != 1 || stats.Utilization != 1 {
+			t.Errorf("esperava uma request em execução, recebeu %+v", stats)
+		}
+
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodPost, "/lento", nil))
+		if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "2" {
+			t.Errorf("esperava 503 com Retry-After 2, recebeu %d %q", w.Code, w.Header().Get("Retry-After"))
+		}
+
+		close(release)
+		wg.Wait()
+		if first.Code != http.StatusOK || bulkhead.Stats().InFlight != 0 || bulkhead.Stats().Rejected != 1 {
+			t.Errorf("estado final inesperado: %d %+v", first.Code, bulkhead.Stats())
+		}
+	})
+
+	t.Run("Testando fila que espera uma vaga", func(t *testing.T) {
+		bulkhead := NewBulkhead(BulkheadConfig{MaxConcurrent: 1, MaxQueue: 1})
+		release, started := make(chan struct{}), make(chan struct{}, 2)
+		handler := newRoute(bulkhead, release, started)
+
+		var wg sync.WaitGroup
+		first := serveAsync(handler, &wg)
+		<-started
+		second := serveAsync(handler, &wg)
+		waitFor(t, func() bool { return bulkhead.Stats().Queued == 1 })
+
+		// fila cheia
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodPost, "/lento", nil))
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("fila cheia deveria rejeitar, recebeu %d", w.Code)
+		}
+
+		close(release)
+		wg.Wait()
+		if first.Code != http.StatusOK || second.Code != http.StatusOK {
+			t.Errorf("requests da fila deveriam passar: %d %d", first.Code, second.Code)
+		}
+	})
+
+	t.Run("Testando timeout e cancelamento na fila", func(t *testing.T) {
+		bulkhead := NewBulkhead(BulkheadConfig{MaxConcurrent: 1, MaxQueue: 2, QueueTimeout: 20 * time.Millisecond})
+		release, started := make(chan struct{}), make(chan struct{}, 2)
+		handler := newRoute(bulkhead, release, started)
+
+		var wg sync.WaitGroup
+		serveAsync(handler, &wg)
+		<-started
+
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodPost, "/lento", nil))
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("timeout na fila deveria responder 503, recebeu %d", w.Code)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		w = httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodPost, "/lento", nil).WithContext(ctx))
+		if bulkhead.Stats().Queued != 0 {
+			t.Errorf("request cancelada deveria sair da fila")
+		}
+
+		close(release)
+		wg.Wait()
+	})
+
+	t.Run("Testando vaga liberada depois de panic", func(t *testing.T) {
+		bulkhead := NewBulkhead(BulkheadConfig{MaxConcurrent: 1})
+		server := NewAPIServer(":6969")
+		handler := server.MakeHTTPHandlerFuncHelper(RouteInfo{
+			Path:        "/panic",
+			Method:      MethodGet,
+			Middlewares: []Middleware{bulkhead.Middleware()},
+			Handler:     APIFunc(func(tc *TupaContext) error { panic("boom") }),
+		})
+
+		handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/panic", nil))
+		if bulkhead.Stats().InFlight != 0 {
+			t.Errorf("vaga deveria ser liberada depois do panic")
+		}
+	})
+}
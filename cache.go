@@ -0,0 +1,546 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CACHE DE RESPOSTAS
+
+const (
+	DefaultCacheTTL           = time.Minute
+	DefaultCacheMaxBytes      = 64 << 20
+	DefaultCacheMaxEntryBytes = 1 << 20
+)
+
+type ResponseCacheConfig struct {
+	// TTL vale para respostas sem max-age no Cache-Control. O padrão é 1 minuto
+	TTL time.Duration
+	// Vary são os headers da request que geram versões diferentes da resposta, como
+	// Accept ou Accept-Language. Devem incluir Accept-Encoding se o cache ficar por fora do Compress
+	Vary []string
+	// MaxBytes é o tamanho total do cache. Quando cheio, as respostas usadas há mais
+	// tempo saem primeiro. O padrão é 64MB
+	MaxBytes int64
+	// MaxEntryBytes é o tamanho máximo de uma resposta guardada. O padrão é 1MB
+	MaxEntryBytes int64
+}
+
+// ResponseCache guarda respostas completas de GET e HEAD em memória. A chave é o método,
+// o path, a query e os headers de Vary. O handler controla o cache pelo Cache-Control
+// (no-store, private, no-cache e max-age/s-maxage) e pode marcar a resposta com tags
+// via tc.CacheTags para invalidação em grupo
+//
+//	cats := NewResponseCache(ResponseCacheConfig{TTL: 5 * time.Minute})
+//	{Path: "/cat-fact", Method: "GET", Handler: HandleCatFact, Middlewares: []Middleware{cats.Middleware()}}
+//	...
+//	cats.InvalidateTag("cats")
+type ResponseCache struct {
+	cfg ResponseCacheConfig
+
+	mu    sync.Mutex
+	size  int64
+	lru   *list.List
+	items map[string]*list.Element
+	// keys liga a chave primária (método, path e query) às variações guardadas
+	keys map[string]map[string]struct{}
+	tags map[string]map[string]struct{}
+}
+
+type cacheEntry struct {
+	key        string
+	primaryKey string
+	status     int
+	header     http.Header
+	body       []byte
+	tags       []string
+	storedAt   time.Time
+	expiresAt  time.Time
+	size       int64
+}
+
+var cacheTagsKey = NewKey[[]string]("tupa.cache_tags")
+
+// CacheTags marca a resposta da request com tags, usadas por ResponseCache.InvalidateTag
+func (tc *TupaContext) CacheTags(tags ...string) {
+	current, _ := cacheTagsKey.Get(tc)
+	cacheTagsKey.Set(tc, append(current, tags...))
+}
+
+func NewResponseCache(cfg ResponseCacheConfig) *ResponseCache {
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultCacheTTL
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = DefaultCacheMaxBytes
+	}
+	if cfg.MaxEntryBytes <= 0 {
+		cfg.MaxEntryBytes = DefaultCacheMaxEntryBytes
+	}
+	// cópia, para não alterar o slice de quem chamou
+	vary := make([]string, len(cfg.Vary))
+	for i, name := range cfg.Vary {
+		vary[i] = textproto.CanonicalMIMEHeaderKey(name)
+	}
+	cfg.Vary = vary
+
+	return &ResponseCache{
+		cfg:   cfg,
+		lru:   list.New(),
+		items: make(map[string]*list.Element),
+		keys:  make(map[string]map[string]struct{}),
+		tags:  make(map[string]map[string]struct{}),
+	}
+}
+
+// CacheKey é a chave primária de uma request, a mesma aceita por Invalidate
+func CacheKey(method, path, rawQuery string) string {
+	return method + " " + path + "?" + rawQuery
+}
+
+func (c *ResponseCache) primaryKey(r *http.Request) string {
+	// HEAD é atendido pela resposta guardada pelo GET, mas nunca guarda a sua, que não tem body
+	method := r.Method
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+	// Encode ordena os parâmetros, então ?a=1&b=2 e ?b=2&a=1 caem na mesma chave
+	return CacheKey(method, r.URL.Path, r.URL.Query().Encode())
+}
+
+func (c *ResponseCache) variantKey(primary string, r *http.Request) string {
+	if len(c.cfg.Vary) == 0 {
+		return primary
+	}
+
+	var b strings.Builder
+	b.WriteString(primary)
+	for _, name := range c.cfg.Vary {
+		b.WriteString("|")
+		b.WriteString(strings.Join(r.Header.Values(name), ","))
+	}
+	return b.String()
+}
+
+func (c *ResponseCache) Middleware() *NamedMiddleware {
+	return Named("cache", func(next APIFunc) APIFunc {
+		return func(tc *TupaContext) (err error) {
+			r := tc.request
+			if !c.cacheableRequest(r) {
+				return next(tc)
+			}
+
+			primary := c.primaryKey(r)
+			key := c.variantKey(primary, r)
+
+			if entry := c.get(key); entry != nil {
+				_, err = c.serveEntry(tc, tc.Writer(), entry, "HIT")
+				return err
+			}
+			if r.Method == http.MethodHead {
+				return next(tc)
+			}
+
+			tracker := tc.Writer()
+			// headers que já estão na resposta vieram de middlewares externos e são da request
+			// atual, como X-Request-ID e RateLimit-*, então ficam fora da entrada
+			cw := &cacheWriter{ResponseWriter: tracker.ResponseWriter, limit: c.cfg.MaxEntryBytes, outer: tracker.Header().Clone()}
+			tracker.ResponseWriter = cw
+			defer func() {
+				tracker.ResponseWriter = cw.ResponseWriter
+			}()
+
+			if err = next(tc); err != nil || cw.passthrough {
+				// erros e respostas em streaming não são guardados
+				cw.flush()
+				return err
+			}
+
+			if !cw.wrote() {
+				return nil
+			}
+
+			entry := c.newEntry(tc, cw, primary, key)
+			if entry == nil {
+				cw.flush()
+				return nil
+			}
+
+			c.set(entry)
+
+			// o tracker já foi marcado como enviado quando o handler escreveu no buffer,
+			// então a resposta vai direto para o writer original
+			status, err := c.serveEntry(tc, cw.ResponseWriter, entry, "MISS")
+			if status == http.StatusNotModified {
+				tracker.status, tracker.size = status, 0
+			}
+			return err
+		}
+	})
+}
+
+func (c *ResponseCache) cacheableRequest(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	// respostas de requests autenticadas são de um usuário só, a não ser que variem por Authorization
+	if r.Header.Get("Authorization") != "" && !containsString(c.cfg.Vary, "Authorization") {
+		return false
+	}
+	// o mesmo vale para sessões em cookie
+	if r.Header.Get("Cookie") != "" && !containsString(c.cfg.Vary, "Cookie") {
+		return false
+	}
+
+	return !cacheControlHas(r.Header.Get("Cache-Control"), "no-store")
+}
+
+// newEntry monta a entrada a partir da resposta capturada, ou retorna nil se ela
+// não puder ser guardada
+func (c *ResponseCache) newEntry(tc *TupaContext, cw *cacheWriter, primary, key string) *cacheEntry {
+	status := cw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if status != http.StatusOK {
+		return nil
+	}
+
+	header := cw.Header()
+	if header.Get("Set-Cookie") != "" {
+		return nil
+	}
+
+	cacheControl := header.Get("Cache-Control")
+	if cacheControlHas(cacheControl, "no-store") || cacheControlHas(cacheControl, "private") || cacheControlHas(cacheControl, "no-cache") {
+		return nil
+	}
+
+	ttl := c.cfg.TTL
+	if maxAge, ok := cacheControlMaxAge(cacheControl); ok {
+		ttl = maxAge
+	}
+	if ttl <= 0 {
+		return nil
+	}
+
+	body := bytes.Clone(cw.buf.Bytes())
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", http.DetectContentType(body))
+	}
+	if header.Get("ETag") == "" {
+		sum := sha256.Sum256(body)
+		header.Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	}
+	header.Del("Content-Length")
+
+	// só os headers que o handler setou ou alterou
+	stored := make(http.Header, len(header))
+	for name, values := range header {
+		if !slices.Equal(values, cw.outer[name]) {
+			stored[name] = append([]string(nil), values...)
+		}
+	}
+
+	tags, _ := cacheTagsKey.Get(tc)
+	now := time.Now()
+	entry := &cacheEntry{
+		key:        key,
+		primaryKey: primary,
+		status:     status,
+		header:     stored,
+		body:       body,
+		tags:       tags,
+		storedAt:   now,
+		expiresAt:  now.Add(ttl),
+	}
+
+	entry.size = int64(len(key) + len(body))
+	for name, values := range entry.header {
+		entry.size += int64(len(name))
+		for _, value := range values {
+			entry.size += int64(len(value))
+		}
+	}
+	if entry.size > c.cfg.MaxEntryBytes {
+		return nil
+	}
+
+	return entry
+}
+
+// serveEntry envia a resposta guardada, ou 304 se o If-None-Match bater com o ETag,
+// e retorna o status enviado
+func (c *ResponseCache) serveEntry(tc *TupaContext, w http.ResponseWriter, entry *cacheEntry, cacheStatus string) (int, error) {
+	header := w.Header()
+	for name, values := range entry.header {
+		header[name] = append([]string(nil), values...)
+	}
+	header.Set("X-Cache", cacheStatus)
+	header.Set("Age", strconv.Itoa(int(time.Since(entry.storedAt).Seconds())))
+	for _, name := range c.cfg.Vary {
+		header.Add("Vary", name)
+	}
+
+	if etagMatches(tc.request.Header.Get("If-None-Match"), header.Get("ETag")) {
+		header.Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+		return http.StatusNotModified, nil
+	}
+
+	header.Set("Content-Length", strconv.Itoa(len(entry.body)))
+	w.WriteHeader(entry.status)
+	if tc.request.Method == http.MethodHead {
+		return entry.status, nil
+	}
+
+	_, err := w.Write(entry.body)
+	return entry.status, err
+}
+
+func (c *ResponseCache) get(key string) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return nil
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.remove(elem)
+		return nil
+	}
+
+	c.lru.MoveToFront(elem)
+	return entry
+}
+
+func (c *ResponseCache) set(entry *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[entry.key]; ok {
+		c.remove(elem)
+	}
+
+	c.items[entry.key] = c.lru.PushFront(entry)
+	c.size += entry.size
+	addToIndex(c.keys, entry.primaryKey, entry.key)
+	for _, tag := range entry.tags {
+		addToIndex(c.tags, tag, entry.key)
+	}
+
+	for c.size > c.cfg.MaxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove tira a entrada do LRU e dos índices. Chamado com o mutex travado
+func (c *ResponseCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.items, entry.key)
+	c.size -= entry.size
+
+	removeFromIndex(c.keys, entry.primaryKey, entry.key)
+	for _, tag := range entry.tags {
+		removeFromIndex(c.tags, tag, entry.key)
+	}
+}
+
+// Invalidate remove todas as versões guardadas da chave criada por CacheKey
+func (c *ResponseCache) Invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.removeAll(c.keys[key])
+}
+
+// InvalidateTag remove as respostas marcadas com a tag
+func (c *ResponseCache) InvalidateTag(tag string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.removeAll(c.tags[tag])
+}
+
+func (c *ResponseCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lru.Init()
+	c.size = 0
+	clear(c.items)
+	clear(c.keys)
+	clear(c.tags)
+}
+
+// Len retorna a quantidade de respostas e o tamanho ocupado pelo cache
+func (c *ResponseCache) Len() (entries int, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len(), c.size
+}
+
+func (c *ResponseCache) removeAll(keys map[string]struct{}) {
+	// copiando antes, já que remove altera o próprio índice
+	pending := make([]string, 0, len(keys))
+	for key := range keys {
+		pending = append(pending, key)
+	}
+
+	for _, key := range pending {
+		if elem, ok := c.items[key]; ok {
+			c.remove(elem)
+		}
+	}
+}
+
+func addToIndex(index map[string]map[string]struct{}, name, key string) {
+	if index[name] == nil {
+		index[name] = make(map[string]struct{})
+	}
+	index[name][key] = struct{}{}
+}
+
+func removeFromIndex(index map[string]map[string]struct{}, name, key string) {
+	delete(index[name], key)
+	if len(index[name]) == 0 {
+		delete(index, name)
+	}
+}
+
+func cacheControlHas(header, directive string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, _, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if strings.EqualFold(name, directive) {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheControlMaxAge lê s-maxage ou, sem ele, max-age. Como o cache é compartilhado
+// entre clientes, s-maxage tem prioridade
+func cacheControlMaxAge(header string) (time.Duration, bool) {
+	var maxAge, sMaxAge string
+	for _, part := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch strings.ToLower(name) {
+		case "max-age":
+			maxAge = value
+		case "s-maxage":
+			sMaxAge = value
+		}
+	}
+
+	if sMaxAge != "" {
+		maxAge = sMaxAge
+	}
+	seconds, err := strconv.Atoi(strings.Trim(maxAge, `"`))
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// etagMatches compara o If-None-Match com o ETag usando a comparação fraca da RFC 9110
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" || etag == "" {
+		return false
+	}
+
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheWriter segura a resposta do handler para que ela possa ser guardada. Respostas
+// maiores que o limite ou com Flush passam direto para o cliente
+type cacheWriter struct {
+	http.ResponseWriter
+	status      int
+	buf         bytes.Buffer
+	limit       int64
+	passthrough bool
+	// outer são os headers da resposta antes do handler rodar
+	outer http.Header
+}
+
+func (cw *cacheWriter) wrote() bool {
+	return cw.status != 0 || cw.buf.Len() > 0
+}
+
+func (cw *cacheWriter) WriteHeader(status int) {
+	if cw.passthrough {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.status = status
+}
+
+func (cw *cacheWriter) Write(b []byte) (int, error) {
+	if cw.passthrough {
+		return cw.ResponseWriter.Write(b)
+	}
+
+	if int64(cw.buf.Len()+len(b)) > cw.limit {
+		if err := cw.flush(); err != nil {
+			return 0, err
+		}
+		return cw.ResponseWriter.Write(b)
+	}
+	return cw.buf.Write(b)
+}
+
+func (cw *cacheWriter) Flush() {
+	cw.flush()
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// flush desiste de guardar a resposta e envia o que foi capturado
+func (cw *cacheWriter) flush() error {
+	if cw.passthrough {
+		return nil
+	}
+	cw.passthrough = true
+
+	if !cw.wrote() {
+		return nil
+	}
+
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	if cw.buf.Len() == 0 {
+		return nil
+	}
+	_, err := cw.ResponseWriter.Write(cw.buf.Bytes())
+	cw.buf.Reset()
+	return err
+}
+
+// Unwrap devolve o writer por baixo do buffer do cache. ResponseController usa ele para
+// deadlines e Hijack; o que for escrito direto nele não entra na resposta guardada
+func (cw *cacheWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
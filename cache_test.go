@@ -0,0 +1,310 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResponseCache(t *testing.T) {
+	newRoute := func(cache *ResponseCache, handler APIFunc) http.HandlerFunc {
+		server := NewAPIServer(":6969")
+		return server.MakeHTTPHandlerFuncHelper(RouteInfo{
+			Path:        "/fatos",
+			Method:      MethodGet,
+			Middlewares: []Middleware{cache.Middleware()},
+			Handler:     handler,
+		})
+	}
+
+	serve := func(handler http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	counting := func(calls *int, setup func(tc *TupaContext)) APIFunc {
+		return func(tc *TupaContext) error {
+			*calls++
+			if setup != nil {
+				setup(tc)
+			}
+			return tc.SendString("fato " + tc.QueryParam("id"))
+		}
+	}
+
+	t.Run("Testando miss e hit", func(t *testing.T) {
+		var calls int
+		handler := newRoute(NewResponseCache(ResponseCacheConfig{}), counting(&calls, nil))
+
+		first := serve(handler, httptest.NewRequest(http.MethodGet, "/fatos?id=1&b=2", nil))
+		second := serve(handler, httptest.NewRequest(http.MethodGet, "/fatos?b=2&id=1", nil))
+
+		if calls != 1 {
+			t.Errorf("esperava que o handler rodasse uma vez, rodou %d", calls)
+		}
+		if first.Header().Get("X-Cache") != "MISS" || second.Header().Get("X-Cache") != "HIT" {
+			t.Errorf("esperava MISS e HIT, recebeu %s e %s", first.Header().Get("X-Cache"), second.Header().Get("X-Cache"))
+		}
+		if second.Body.String() != "fato 1" || second.Code != http.StatusOK {
+			t.Errorf("resposta do cache inesperada: %d %s", second.Code, second.Body.String())
+		}
+		if first.Header().Get("ETag") == "" || first.Header().Get("ETag") != second.Header().Get("ETag") {
+			t.Errorf("esperava o mesmo ETag nas duas respostas, recebeu %q e %q", first.Header().Get("ETag"), second.Header().Get("ETag"))
+		}
+
+		serve(handler, httptest.NewRequest(http.MethodGet, "/fatos?id=2", nil))
+		if calls != 2 {
+			t.Errorf("query diferente deveria gerar outra entrada, handler rodou %d vezes", calls)
+		}
+	})
+
+	t.Run("Testando headers de Vary", func(t *testing.T) {
+		var calls int
+		vary := []string{"accept-language"}
+		cache := NewResponseCache(ResponseCacheConfig{Vary: vary})
+		handler := newRoute(cache, counting(&calls, nil))
+
+		if vary[0] != "accept-language" {
+			t.Errorf("NewResponseCache não deveria alterar o Vary de quem chamou: %q", vary[0])
+		}
+
+		for _, lang := range []string{"pt-BR", "en", "pt-BR"} {
+			req := httptest.NewRequest(http.MethodGet, "/fatos", nil)
+			req.Header.Set("Accept-Language", lang)
+			w := serve(handler, req)
+
+			if w.Header().Get("Vary") != "Accept-Language" {
+				t.Errorf("esperava Vary: Accept-Language, recebeu %q", w.Header().Get("Vary"))
+			}
+		}
+
+		if calls != 2 {
+			t.Errorf("esperava uma entrada por idioma, handler rodou %d vezes", calls)
+		}
+	})
+
+	t.Run("Testando Cache-Control do handler", func(t *testing.T) {
+		var calls int
+		handler := newRoute(NewResponseCache(ResponseCacheConfig{}), counting(&calls, func(tc *TupaContext) {
+			tc.Writer().Header().Set("Cache-Control", "no-store")
+		}))
+
+		serve(handler, httptest.NewRequest(http.MethodGet, "/fatos", nil))
+		w := serve(handler, httptest.NewRequest(http.MethodGet, "/fatos", nil))
+
+		if calls != 2 {
+			t.Errorf("no-store não deveria ser guardado, handler rodou %d vezes", calls)
+		}
+		if w.Body.String() != "fato " {
+			t.Errorf("body inesperado: %q", w.Body.String())
+		}
+
+		if ttl, ok := cacheControlMaxAge("public, max-age=60, s-maxage=10"); !ok || ttl.Seconds() != 10 {
+			t.Errorf("esperava s-maxage de 10s, recebeu %v %v", ttl, ok)
+		}
+	})
+
+	t.Run("Testando respostas que não são guardadas", func(t *testing.T) {
+		var calls int
+		handler := newRoute(NewResponseCache(ResponseCacheConfig{}), func(tc *TupaContext) error {
+			calls++
+			if tc.QueryParam("erro") != "" {
+				return NotFound("fato não encontrado")
+			}
+			return tc.SendString("fato")
+		})
+
+		for i := 0; i < 2; i++ {
+			w := serve(handler, httptest.NewRequest(http.MethodGet, "/fatos?erro=1", nil))
+			if w.Code != http.StatusNotFound {
+				t.Errorf("esperava status 404, recebeu %d", w.Code)
+			}
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/fatos", nil)
+		req.Header.Set("Authorization", "Bearer token")
+		serve(handler, req)
+		serve(handler, req)
+
+		req = httptest.NewRequest(http.MethodGet, "/fatos", nil)
+		req.Header.Set("Cookie", "session=abc")
+		serve(handler, req)
+		serve(handler, req)
+
+		if calls != 6 {
+			t.Errorf("erros e requests autenticadas não deveriam ser guardados, handler rodou %d vezes", calls)
+		}
+	})
+
+	t.Run("Testando cookie com Vary: Cookie", func(t *testing.T) {
+		var calls int
+		handler := newRoute(NewResponseCache(ResponseCacheConfig{Vary: []string{"Cookie"}}), counting(&calls, nil))
+
+		for _, cookie := range []string{"session=abc", "session=xyz", "session=abc"} {
+			req := httptest.NewRequest(http.MethodGet, "/fatos", nil)
+			req.Header.Set("Cookie", cookie)
+			serve(handler, req)
+		}
+
+		if calls != 2 {
+			t.Errorf("esperava uma entrada por cookie, handler rodou %d vezes", calls)
+		}
+	})
+
+	t.Run("Testando 304 com If-None-Match", func(t *testing.T) {
+		var calls int
+		handler := newRoute(NewResponseCache(ResponseCacheConfig{}), counting(&calls, nil))
+
+		first := serve(handler, httptest.NewRequest(http.MethodGet, "/fatos", nil))
+		etag := first.Header().Get("ETag")
+
+		req := httptest.NewRequest(http.MethodGet, "/fatos", nil)
+		req.Header.Set("If-None-Match", `"outro", `+etag)
+		w := serve(handler, req)
+
+		if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+			t.Errorf("esperava 304 sem body, recebeu %d %q", w.Code, w.Body.String())
+		}
+
+		other := newRoute(NewResponseCache(ResponseCacheConfig{}), counting(&calls, nil))
+		if w := serve(other, req); w.Code != http.StatusNotModified || w.Header().Get("X-Cache") != "MISS" {
+			t.Errorf("esperava 304 mesmo no miss, recebeu %d %s", w.Code, w.Header().Get("X-Cache"))
+		}
+	})
+
+	t.Run("Testando invalidação por chave e por tag", func(t *testing.T) {
+		var calls int
+		cache := NewResponseCache(ResponseCacheConfig{Vary: []string{"Accept"}})
+		handler := newRoute(cache, counting(&calls, func(tc *TupaContext) {
+			tc.CacheTags("fatos")
+		}))
+
+		request := func(accept string) {
+			req := httptest.NewRequest(http.MethodGet, "/fatos?id=1", nil)
+			req.Header.Set("Accept", accept)
+			serve(handler, req)
+		}
+
+		request("text/plain")
+		request("application/json")
+		if entries, _ := cache.Len(); entries != 2 {
+			t.Fatalf("esperava 2 entradas, recebeu %d", entries)
+		}
+
+		cache.Invalidate(CacheKey(http.MethodGet, "/fatos", "id=1"))
+		if entries, size := cache.Len(); entries != 0 || size != 0 {
+			t.Errorf("esperava o cache vazio após Invalidate, recebeu %d entradas e %d bytes", entries, size)
+		}
+
+		request("text/plain")
+		cache.InvalidateTag("fatos")
+		request("text/plain")
+
+		if calls != 4 {
+			t.Errorf("esperava que o handler rodasse 4 vezes, rodou %d", calls)
+		}
+	})
+
+	t.Run("Testando despejo do LRU por tamanho", func(t *testing.T) {
+		body := strings.Repeat("a", 400)
+		cache := NewResponseCache(ResponseCacheConfig{MaxBytes: 1200, MaxEntryBytes: 1000})
+		handler := newRoute(cache, func(tc *TupaContext) error {
+			return tc.SendString(body)
+		})
+
+		get := func(id string) string {
+			return serve(handler, httptest.NewRequest(http.MethodGet, "/fatos?id="+id, nil)).Header().Get("X-Cache")
+		}
+
+		get("1")
+		get("2")
+		get("1") // 1 passa a ser o mais recente
+		get("3")
+
+		if entries, size := cache.Len(); entries != 2 || size > 1200 {
+			t.Errorf("esperava 2 entradas dentro do limite, recebeu %d entradas e %d bytes", entries, size)
+		}
+		if get("1") != "HIT" || get("2") != "MISS" {
+			t.Errorf("esperava que a entrada 2, menos usada, fosse despejada")
+		}
+	})
+
+	t.Run("Testando que headers de middlewares externos não são guardados", func(t *testing.T) {
+		var calls int
+		server := NewAPIServer(":6969")
+		server.UseGlobalMiddleware(RequestIDMiddleware(RequestIDConfig{}))
+		handler := server.MakeHTTPHandlerFuncHelper(RouteInfo{
+			Path:        "/fatos",
+			Method:      MethodGet,
+			Middlewares: []Middleware{NewResponseCache(ResponseCacheConfig{}).Middleware()},
+			Handler: counting(&calls, func(tc *TupaContext) {
+				tc.Writer().Header().Set("X-Fonte", "handler")
+			}),
+		})
+
+		for _, id := range []string{"first-id", "second-id"} {
+			req := httptest.NewRequest(http.MethodGet, "/fatos", nil)
+			req.Header.Set("X-Request-ID", id)
+			w := serve(handler, req)
+
+			if got := w.Header().Get("X-Request-ID"); got != id {
+				t.Errorf("esperava o ID da request atual %q, recebeu %q", id, got)
+			}
+			if w.Header().Get("X-Fonte") != "handler" {
+				t.Errorf("headers do handler deveriam vir do cache: %v", w.Header())
+			}
+		}
+		if calls != 1 {
+			t.Errorf("a segunda request deveria vir do cache, handler rodou %d vezes", calls)
+		}
+	})
+
+	t.Run("Testando que HEAD não guarda resposta", func(t *testing.T) {
+		var calls int
+		cache := NewResponseCache(ResponseCacheConfig{})
+		server := NewAPIServer(":6969")
+		route := func(method HTTPMethod) http.HandlerFunc {
+			return server.MakeHTTPHandlerFuncHelper(RouteInfo{
+				Path:        "/fatos",
+				Method:      method,
+				Middlewares: []Middleware{cache.Middleware()},
+				Handler:     counting(&calls, nil),
+			})
+		}
+		head, get := route(HTTPMethod(http.MethodHead)), route(MethodGet)
+
+		serve(head, httptest.NewRequest(http.MethodHead, "/fatos?id=1", nil))
+		w := serve(get, httptest.NewRequest(http.MethodGet, "/fatos?id=1", nil))
+
+		if w.Body.String() != "fato 1" || w.Header().Get("X-Cache") != "MISS" {
+			t.Errorf("GET depois de HEAD deveria rodar o handler: %q %q", w.Header().Get("X-Cache"), w.Body.String())
+		}
+
+		w = serve(head, httptest.NewRequest(http.MethodHead, "/fatos?id=1", nil))
+		if w.Header().Get("X-Cache") != "HIT" || w.Body.Len() != 0 {
+			t.Errorf("HEAD deveria usar a resposta guardada pelo GET: %q %q", w.Header().Get("X-Cache"), w.Body.String())
+		}
+		if calls != 2 {
+			t.Errorf("esperava o handler rodando no primeiro HEAD e no GET, rodou %d vezes", calls)
+		}
+	})
+
+	t.Run("Testando resposta maior que o limite", func(t *testing.T) {
+		body := strings.Repeat("a", 2048)
+		cache := NewResponseCache(ResponseCacheConfig{MaxEntryBytes: 1024})
+		handler := newRoute(cache, func(tc *TupaContext) error {
+			return tc.SendString(body)
+		})
+
+		w := serve(handler, httptest.NewRequest(http.MethodGet, "/fatos", nil))
+
+		if w.Body.String() != body {
+			t.Errorf("esperava o body completo, recebeu %d bytes", w.Body.Len())
+		}
+		if entries, _ := cache.Len(); entries != 0 {
+			t.Errorf("resposta grande não deveria ser guardada, cache tem %d entradas", entries)
+		}
+	})
+}
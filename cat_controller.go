@@ -20,7 +20,7 @@ func FetchCatDataAPI(ctx context.Context) (CatStruct, error) {
 		return CatStruct{}, err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := HTTPClient.Do(req)
 	if err != nil {
 		return CatStruct{Fact: "Nenhum fato retornado", Length: -1}, err
 	}
@@ -41,16 +41,21 @@ type CatDataResponse struct {
 	err   error
 }
 
-func GetCatData(cc context.Context, w http.ResponseWriter, r *http.Request) error {
-	// pegando valor do context
-	userID, ok := cc.Value("userID").(string)
+var catUserIDKey = NewKey[string]("userID")
+
+func GetCatData(tc *TupaContext) error {
+	// pegando valor do store da request
+	userID, ok := catUserIDKey.Get(tc)
 	if !ok {
-		return errors.New("userID not found in context")
+		return Unauthorized("userID not found in context", "")
 	}
 
-	ctx, cancel := context.WithCancel(cc)
+	// a goroutine pode continuar rodando depois do handler retornar, então usa o context da
+	// request e não o tc, que volta para o pool
+	ctx, cancel := context.WithCancel(tc.Request().Context())
 	defer cancel() // garantindo que o ctx seja cancelado antes da função terminar para não vazar nenhum ctx
-	respch := make(chan CatDataResponse)
+	// com buffer a goroutine consegue enviar a resposta e terminar mesmo se ninguém mais estiver esperando
+	respch := make(chan CatDataResponse, 1)
 
 	fmt.Println("UserID: ", userID)
 
@@ -65,10 +70,10 @@ func GetCatData(cc context.Context, w http.ResponseWriter, r *http.Request) erro
 	// for select, vamos sincronizar isso td
 	for {
 		select { // o select permite uma goroutine esperar em multiplos operadores de comunicação
-		case <-cc.Done():
-			return fmt.Errorf("Request cancelada: %v", cc.Err())
+		case <-tc.Done():
+			return fmt.Errorf("Request cancelada: %v", tc.Err())
 		case resp := <-respch:
-			WriteJSONHelper(w, http.StatusOK, resp.value)
+			WriteJSONHelper(tc.Writer(), http.StatusOK, resp.value)
 
 			fmt.Println("CatData: ", resp.value)
 			return resp.err
@@ -81,12 +86,51 @@ func GetCatData(cc context.Context, w http.ResponseWriter, r *http.Request) erro
 }
 
 func PassingCtxCatData(tc *TupaContext) error {
-	userID := "2602"
-	reqCtx := context.WithValue(tc.request.Context(), "userID", userID)
+	catUserIDKey.Set(tc, "2602")
 
 	start := time.Now()
-	err := GetCatData(reqCtx, tc.response, tc.request)
+	err := GetCatData(tc)
 	fmt.Println("Tempo de execução: ", time.Since(start))
 
 	return err
 }
+
+type CatFactRequest struct {
+	MaxLength int `query:"max_length" default:"140"`
+}
+
+func (r CatFactRequest) Validate() error {
+	if r.MaxLength < 20 {
+		return errors.New("max_length precisa ser pelo menos 20")
+	}
+	return nil
+}
+
+var HandleCatFact = Handle(func(ctx context.Context, req CatFactRequest) (CatStruct, error) {
+	catData, err := FetchCatDataAPI(ctx)
+	if err != nil {
+		return CatStruct{}, err
+	}
+
+	if fact := []rune(catData.Fact); len(fact) > req.MaxLength {
+		catData.Fact = string(fact[:req.MaxLength])
+		catData.Length = req.MaxLength
+	}
+
+	return catData, nil
+})
+
+// os fatos mudam pouco, então guardamos a resposta da API externa por alguns minutos.
+// HandleCatFact responde JSON, XML ou texto conforme o Accept, então cada formato tem sua entrada
+var catFactCache = NewResponseCache(ResponseCacheConfig{TTL: 5 * time.Minute, Vary: []string{"Accept"}})
+
+func ContrCatRoutes() []RouteInfo {
+	return []RouteInfo{
+		{
+			Path:        "/cat-fact",
+			Method:      "GET",
+			Handler:     HandleCatFact,
+			Middlewares: []Middleware{catFactCache.Middleware()},
+		},
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestCatFactRoute(t *testing.T) {
+	t.Run("Testando cache separado por Accept", func(t *testing.T) {
+		var calls int
+		client := *HTTPClient
+		HTTPClient.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			calls++
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": {"application/json"}},
+				Body:       io.NopCloser(strings.NewReader(`{"fact":"gatos dormem muito","length":19}`)),
+			}, nil
+		})
+		catFactCache.Purge()
+		defer func() {
+			*HTTPClient = client
+			catFactCache.Purge()
+		}()
+
+		server := NewAPIServer(":6969")
+		handler := server.MakeHTTPHandlerFuncHelper(ContrCatRoutes()[0])
+		serve := func(accept string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "/cat-fact", nil)
+			req.Header.Set("Accept", accept)
+			w := httptest.NewRecorder()
+			handler(w, req)
+			return w
+		}
+
+		asJSON := serve("application/json")
+		asXML := serve("application/xml")
+		again := serve("application/xml")
+
+		if !strings.HasPrefix(asJSON.Header().Get("Content-Type"), "application/json") {
+			t.Errorf("esperava JSON, recebeu %q", asJSON.Header().Get("Content-Type"))
+		}
+		if !strings.HasPrefix(asXML.Header().Get("Content-Type"), "application/xml") || asXML.Header().Get("X-Cache") != "MISS" {
+			t.Errorf("Accept diferente não deveria receber o JSON guardado: %q %q", asXML.Header().Get("Content-Type"), asXML.Header().Get("X-Cache"))
+		}
+		if again.Header().Get("X-Cache") != "HIT" || again.Body.String() != asXML.Body.String() {
+			t.Errorf("mesmo Accept deveria vir do cache: %q", again.Header().Get("X-Cache"))
+		}
+		if calls != 2 {
+			t.Errorf("esperava uma chamada à API por formato, foram %d", calls)
+		}
+	})
+}
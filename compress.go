@@ -0,0 +1,349 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// COMPRESSÃO
+
+const DefaultCompressMinSize = 1024
+
+// tipos que valem a pena comprimir. Imagens, vídeos e arquivos compactados já são comprimidos
+var defaultCompressTypes = []string{
+	"text/*",
+	"application/json",
+	"application/xml",
+	"application/javascript",
+	"application/x-javascript",
+	"application/problem+json",
+	"application/ld+json",
+	"image/svg+xml",
+}
+
+type CompressConfig struct {
+	// Level é o nível de compressão do gzip/zlib. Zero usa o padrão da biblioteca
+	Level int
+	// MinSize é o tamanho mínimo do body para comprimir. O padrão é 1KB; respostas menores
+	// ficam maiores comprimidas. Respostas com Flush são comprimidas sempre
+	MinSize int
+	// ContentTypes são os tipos comprimidos, aceitando "tipo/*". O padrão cobre texto, JSON, XML e JS
+	ContentTypes []string
+}
+
+// Compress cria um middleware que comprime a resposta com gzip ou deflate conforme o
+// Accept-Encoding. Requests com Range, respostas que já têm Content-Encoding e tipos fora
+// da allowlist passam sem alteração; ETags fortes viram fracos, já que os bytes mudam
+func Compress(cfg CompressConfig) *NamedMiddleware {
+	if cfg.Level == 0 {
+		cfg.Level = gzip.DefaultCompression
+	}
+	if cfg.MinSize <= 0 {
+		cfg.MinSize = DefaultCompressMinSize
+	}
+	if len(cfg.ContentTypes) == 0 {
+		cfg.ContentTypes = defaultCompressTypes
+	}
+
+	c := &compressor{cfg: cfg}
+	c.writers.New = func() any {
+		return &compressWriter{compressor: c, buf: make([]byte, 0, cfg.MinSize)}
+	}
+	c.gzipPool.New = func() any {
+		w, _ := gzip.NewWriterLevel(io.Discard, cfg.Level)
+		return w
+	}
+	c.zlibPool.New = func() any {
+		w, _ := zlib.NewWriterLevel(io.Discard, cfg.Level)
+		return w
+	}
+
+	return Named("compress", func(next APIFunc) APIFunc {
+		return func(tc *TupaContext) (err error) {
+			tc.response.Header().Add("Vary", "Accept-Encoding")
+
+			encoding := c.encodingFor(tc.request)
+			if encoding == "" {
+				return next(tc)
+			}
+
+			tracker := tc.Writer()
+			cw := c.writers.Get().(*compressWriter)
+			cw.reset(tracker.ResponseWriter, encoding)
+			tracker.ResponseWriter = cw
+
+			finish := func() error {
+				closeErr := cw.close()
+				tracker.ResponseWriter = cw.ResponseWriter
+				cw.reset(nil, "")
+				c.writers.Put(cw)
+				return closeErr
+			}
+
+			// em defer para que, num panic, o que já foi escrito chegue ao cliente e o
+			// recover do servidor escreva direto no writer original
+			returned := false
+			defer func() {
+				switch {
+				case !returned:
+					finish()
+				case err != nil && !tracker.Committed():
+					// o servidor ainda vai escrever o erro, que também deve sair comprimido
+					tc.onRelease(func() {
+						if closeErr := finish(); closeErr != nil {
+							tc.Logger().Error("erro ao fechar compressão", "error", closeErr)
+						}
+					})
+				default:
+					if closeErr := finish(); closeErr != nil && err == nil {
+						err = closeErr
+					}
+				}
+			}()
+
+			err = next(tc)
+			returned = true
+			return err
+		}
+	})
+}
+
+type compressor struct {
+	cfg      CompressConfig
+	writers  sync.Pool
+	gzipPool sync.Pool
+	zlibPool sync.Pool
+}
+
+// resettableWriter é o que gzip.Writer e zlib.Writer têm em comum
+type resettableWriter interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+func (c *compressor) encodingFor(r *http.Request) string {
+	// Range aponta para bytes da representação original, e HEAD e upgrades não têm body
+	if r.Method == http.MethodHead || r.Header.Get("Range") != "" || r.Header.Get("Upgrade") != "" {
+		return ""
+	}
+	return negotiateEncoding(r.Header.Get("Accept-Encoding"))
+}
+
+// negotiateEncoding escolhe gzip ou deflate pelo q do Accept-Encoding, preferindo gzip
+// no empate
+func negotiateEncoding(accept string) string {
+	if accept == "" {
+		return ""
+	}
+
+	q := map[string]float64{}
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		value := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				value = parsed
+			}
+		}
+		q[strings.ToLower(strings.TrimSpace(name))] = value
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range []string{"gzip", "deflate"} {
+		value, ok := q[encoding]
+		if !ok {
+			value, ok = q["*"]
+		}
+		if ok && value > bestQ {
+			best, bestQ = encoding, value
+		}
+	}
+	return best
+}
+
+func (c *compressor) allowedType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, allowed := range c.cfg.ContentTypes {
+		if allowed == mediaType || (strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressWriter fica entre o TupaResponseWriter e o writer do net/http. Ele segura o
+// status e os primeiros bytes até saber se a resposta deve ser comprimida
+type compressWriter struct {
+	http.ResponseWriter
+	compressor *compressor
+	encoding   string
+	status     int
+	buf        []byte
+	decided    bool
+	writer     resettableWriter
+}
+
+func (cw *compressWriter) reset(w http.ResponseWriter, encoding string) {
+	cw.ResponseWriter = w
+	cw.encoding = encoding
+	cw.status = 0
+	cw.buf = cw.buf[:0]
+	cw.decided = false
+	cw.writer = nil
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.decided {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	// respostas informativas (103 Early Hints) não têm body e podem se repetir
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	cw.status = status
+	if !bodyAllowed(status) {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.decided {
+		if len(cw.buf)+len(b) < cw.compressor.cfg.MinSize {
+			cw.buf = append(cw.buf, b...)
+			return len(b), nil
+		}
+
+		cw.buf = append(cw.buf, b...)
+		if err := cw.decide(false); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	if cw.writer != nil {
+		return cw.writer.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// decide envia os headers, comprimindo ou não, e escreve o que estava no buffer.
+// force ignora o tamanho mínimo, usado quando o handler faz Flush
+func (cw *compressWriter) decide(force bool) error {
+	cw.decided = true
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	header := cw.ResponseWriter.Header()
+	if header.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		// sem isso o net/http detectaria o tipo pelos bytes já comprimidos
+		header.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+
+	if cw.shouldCompress(force) {
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+		header.Del("Accept-Ranges")
+		if etag := header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			header.Set("ETag", "W/"+etag)
+		}
+
+		if cw.encoding == "gzip" {
+			cw.writer = cw.compressor.gzipPool.Get().(*gzip.Writer)
+		} else {
+			cw.writer = cw.compressor.zlibPool.Get().(*zlib.Writer)
+		}
+		cw.writer.Reset(cw.ResponseWriter)
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	if len(cw.buf) == 0 {
+		return nil
+	}
+
+	var err error
+	if cw.writer != nil {
+		_, err = cw.writer.Write(cw.buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf)
+	}
+	cw.buf = cw.buf[:0]
+	return err
+}
+
+func (cw *compressWriter) shouldCompress(force bool) bool {
+	header := cw.ResponseWriter.Header()
+
+	switch {
+	case !bodyAllowed(cw.status), cw.status == http.StatusPartialContent:
+		return false
+	case header.Get("Content-Encoding") != "":
+		return false
+	case !force && len(cw.buf) < cw.compressor.cfg.MinSize:
+		return false
+	default:
+		return cw.compressor.allowedType(header.Get("Content-Type"))
+	}
+}
+
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide(true)
+	}
+	if cw.writer != nil {
+		cw.writer.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Unwrap devolve o writer que recebe os bytes já comprimidos, para que ResponseController
+// chegue às deadlines e ao Hijack da conexão sem passar pelo gzip
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// close finaliza a resposta: decide o que estava pendente e fecha o compressor,
+// que volta para o pool
+func (cw *compressWriter) close() error {
+	var err error
+	if !cw.decided && (cw.status != 0 || len(cw.buf) > 0) {
+		err = cw.decide(false)
+	}
+
+	if cw.writer == nil {
+		return err
+	}
+
+	if closeErr := cw.writer.Close(); err == nil {
+		err = closeErr
+	}
+
+	cw.writer.Reset(io.Discard)
+	if gz, ok := cw.writer.(*gzip.Writer); ok {
+		cw.compressor.gzipPool.Put(gz)
+	} else {
+		cw.compressor.zlibPool.Put(cw.writer)
+	}
+	cw.writer = nil
+	return err
+}
+
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompress(t *testing.T) {
+	serveCompressed := func(handler APIFunc, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/dados", nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		return serveRoute(RouteInfo{Path: "/dados", Method: MethodGet, Handler: handler}, req, Compress(CompressConfig{}))
+	}
+
+	bigJSON := func(tc *TupaContext) error {
+		return WriteJSONHelper(tc.Writer(), http.StatusOK, map[string]string{"fato": strings.Repeat("gatos dormem muito. ", 200)})
+	}
+
+	t.Run("Testando JSON com gzip", func(t *testing.T) {
+		w := serveCompressed(bigJSON, map[string]string{"Accept-Encoding": "gzip, deflate"})
+
+		if w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("Vary") != "Accept-Encoding" {
+			t.Fatalf("headers inesperados: %v", w.Header())
+		}
+
+		reader, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatalf("body não é gzip: %v", err)
+		}
+		body, _ := io.ReadAll(reader)
+		if !strings.Contains(string(body), "gatos dormem muito") {
+			t.Errorf("body descomprimido inesperado: %.50s", body)
+		}
+	})
+
+	t.Run("Testando deflate pelo q do Accept-Encoding", func(t *testing.T) {
+		w := serveCompressed(bigJSON, map[string]string{"Accept-Encoding": "gzip;q=0.5, deflate"})
+
+		if w.Header().Get("Content-Encoding") != "deflate" {
+			t.Fatalf("esperava deflate, recebeu %q", w.Header().Get("Content-Encoding"))
+		}
+		if _, err := zlib.NewReader(w.Body); err != nil {
+			t.Errorf("body não é deflate (zlib): %v", err)
+		}
+	})
+
+	t.Run("Testando respostas que não devem ser comprimidas", func(t *testing.T) {
+		tests := map[string]struct {
+			handler APIFunc
+			header  map[string]string
+		}{
+			"sem Accept-Encoding": {bigJSON, nil},
+			"resposta pequena": {func(tc *TupaContext) error {
+				return WriteJSONHelper(tc.Writer(), http.StatusOK, "oi")
+			}, map[string]string{"Accept-Encoding": "gzip"}},
+			"tipo já comprimido": {func(tc *TupaContext) error {
+				tc.Writer().Header().Set("Content-Type", "image/png")
+				_, err := tc.Writer().Write(make([]byte, 4096))
+				return err
+			}, map[string]string{"Accept-Encoding": "gzip"}},
+			"Content-Encoding do handler": {func(tc *TupaContext) error {
+				tc.Writer().Header().Set("Content-Type", "text/plain")
+				tc.Writer().Header().Set("Content-Encoding", "br")
+				_, err := tc.Writer().Write(make([]byte, 4096))
+				return err
+			}, map[string]string{"Accept-Encoding": "gzip"}},
+		}
+
+		for name, test := range tests {
+			w := serveCompressed(test.handler, test.header)
+			if enc := w.Header().Get("Content-Encoding"); enc == "gzip" {
+				t.Errorf("%s: não deveria comprimir", name)
+			}
+		}
+	})
+
+	t.Run("Testando Range e ETag em arquivos", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "dados.txt")
+		os.WriteFile(path, []byte(strings.Repeat("0123456789", 500)), 0o644)
+		fileHandler := func(tc *TupaContext) error { return tc.File(path) }
+
+		w := serveCompressed(fileHandler, map[string]string{"Accept-Encoding": "gzip"})
+		if w.Header().Get("Content-Encoding") != "gzip" || !strings.HasPrefix(w.Header().Get("ETag"), `W/"`) {
+			t.Errorf("arquivo comprimido deveria ter ETag fraco: %v", w.Header())
+		}
+		if w.Header().Get("Content-Length") != "" || w.Header().Get("Accept-Ranges") != "" {
+			t.Errorf("Content-Length e Accept-Ranges não valem para o body comprimido: %v", w.Header())
+		}
+
+		w = serveCompressed(fileHandler, map[string]string{"Accept-Encoding": "gzip", "Range": "bytes=2-4"})
+		if w.Code != http.StatusPartialContent || w.Header().Get("Content-Encoding") != "" || w.Body.String() != "234" {
+			t.Errorf("Range deveria ignorar a compressão: %d %v %q", w.Code, w.Header(), w.Body.String())
+		}
+	})
+
+	t.Run("Testando Flush em resposta em streaming", func(t *testing.T) {
+		w := serveCompressed(func(tc *TupaContext) error {
+			tc.Writer().Header().Set("Content-Type", "text/event-stream")
+			tc.Writer().Write([]byte("data: 1\n\n"))
+			tc.Writer().Flush()
+
+			if !recorderFlushed(tc) {
+				t.Errorf("Flush deveria chegar ao writer original")
+			}
+			return nil
+		}, map[string]string{"Accept-Encoding": "gzip"})
+
+		reader, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatalf("stream deveria ser comprimido mesmo pequeno: %v", err)
+		}
+		body, _ := io.ReadAll(reader)
+		if string(body) != "data: 1\n\n" {
+			t.Errorf("evento inesperado: %q", body)
+		}
+	})
+
+	t.Run("Testando erro do handler comprimido", func(t *testing.T) {
+		w := serveCompressed(func(tc *TupaContext) error {
+			return BadRequest(strings.Repeat("campo inválido ", 100))
+		}, map[string]string{"Accept-Encoding": "gzip"})
+
+		if w.Code != http.StatusBadRequest || w.Header().Get("Content-Encoding") != "gzip" {
+			t.Errorf("erro deveria sair comprimido com 400: %d %v", w.Code, w.Header())
+		}
+	})
+}
+
+// recorderFlushed olha o httptest.ResponseRecorder por baixo do compressWriter
+func recorderFlushed(tc *TupaContext) bool {
+	cw := tc.Writer().ResponseWriter.(*compressWriter)
+	return cw.ResponseWriter.(*httptest.ResponseRecorder).Flushed
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                        "",
+		"gzip":                    "gzip",
+		"deflate, gzip":           "gzip",
+		"gzip;q=0, deflate":       "deflate",
+		"br":                      "",
+		"*":                       "gzip",
+		"identity, *;q=0":         "",
+		"GZIP;q=0.8, deflate;q=1": "deflate",
+	}
+
+	for accept, want := range tests {
+		if got := negotiateEncoding(accept); got != want {
+			t.Errorf("Accept-Encoding %q: esperava %q, recebeu %q", accept, want, got)
+		}
+	}
+}
+
+func BenchmarkCompressJSONRoute(b *testing.B) {
+	server := NewAPIServer(":6969")
+	server.UseGlobalMiddleware(Compress(CompressConfig{}))
+	handler := server.MakeHTTPHandlerFuncHelper(RouteInfo{
+		Path:   "/bench",
+		Method: MethodGet,
+		Handler: APIFunc(func(tc *TupaContext) error {
+			return WriteJSONHelper(tc.Writer(), http.StatusOK, benchMessage{Message: strings.Repeat("Hello, World! ", 100)})
+		}),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/bench", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := &benchWriter{header: make(http.Header)}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		clear(w.header)
+		handler(w, req)
+	}
+}
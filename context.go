@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// O TupaContext é um context.Context ligado ao ciclo de vida da request: ele é cancelado
+// quando o cliente desconecta, quando o servidor encerra a request ou quando algum
+// middleware aplica um timeout via WithContext. Pode ser passado direto para chamadas externas
+
+// Um context criado a partir do próprio tc, como context.WithTimeout(tc, ...), termina as
+// buscas chamando o tc de volta. Se esse context for instalado com WithContext, o tc delegaria
+// para ele e a busca nunca terminaria. Por isso o tc guarda em valueLayers o context que
+// respondia antes de cada instalação desse tipo, e cada volta ao tc desce uma camada
+
+// layer retorna o context que responde a uma chamada do tc na profundidade depth. Na
+// profundidade 0 é o context atual da request
+func (tc *TupaContext) layer(depth int32) context.Context {
+	switch {
+	case depth == 0 && tc.request != nil:
+		return tc.request.Context()
+	case depth == 0 && tc.Context != nil:
+		return tc.Context
+	case depth > 0 && int(depth) <= len(tc.valueLayers):
+		return tc.valueLayers[len(tc.valueLayers)-int(depth)]
+	}
+	return context.Background()
+}
+
+// enter marca a entrada em um método de context.Context e retorna a profundidade atual
+func (tc *TupaContext) enter() int32 {
+	return tc.depth.Add(1) - 1
+}
+
+func (tc *TupaContext) leave() {
+	tc.depth.Add(-1)
+}
+
+func (tc *TupaContext) Deadline() (time.Time, bool) {
+	defer tc.leave()
+	return tc.layer(tc.enter()).Deadline()
+}
+
+func (tc *TupaContext) Done() <-chan struct{} {
+	defer tc.leave()
+	return tc.layer(tc.enter()).Done()
+}
+
+func (tc *TupaContext) Err() error {
+	defer tc.leave()
+	return tc.layer(tc.enter()).Err()
+}
+
+// Value procura a chave no context atual da request, então valores adicionados com WithContext
+// ou trocando a request aparecem aqui
+func (tc *TupaContext) Value(key any) any {
+	switch key.(type) {
+	case tupaContextKey:
+		return tc
+	case requestIDKey:
+		if tc.requestID != "" {
+			return tc.requestID
+		}
+	}
+
+	defer tc.leave()
+	return tc.layer(tc.enter()).Value(key)
+}
+
+// tupaContextKey permite descobrir se um context foi derivado deste TupaContext
+type tupaContextKey struct{}
+
+// WithContext troca o context da request, mantendo o *http.Request e o TupaContext em sincronia
+//
+//	ctx, cancel := context.WithTimeout(tc, 2*time.Second)
+//	defer cancel()
+//	tc.WithContext(ctx)
+//
+// ctx pode ser derivado do próprio tc; nesse caso o tc passa a seguir o prazo, o cancelamento
+// e os valores de ctx. Como as buscas nesses contexts passam pelo tc, ele não deve ser usado por
+// várias goroutines ao mesmo tempo
+func (tc *TupaContext) WithContext(ctx context.Context) {
+	previous := tc.layer(0)
+	if tc.request != nil {
+		tc.request = tc.request.WithContext(ctx)
+	}
+
+	tc.setContext(ctx, previous)
+}
+
+// SetRequest troca a request do contexto, útil para middlewares que precisam
+// clonar ou alterar o *http.Request
+func (tc *TupaContext) SetRequest(r *http.Request) {
+	previous := tc.layer(0)
+	tc.request = r
+	tc.setContext(r.Context(), previous)
+}
+
+// setContext registra ctx como o context atual. previous é o context que respondia antes
+func (tc *TupaContext) setContext(ctx context.Context, previous context.Context) {
+	if ctx.Value(tupaContextKey{}) == tc {
+		tc.valueLayers = append(tc.valueLayers, previous)
+	} else {
+		clear(tc.valueLayers)
+		tc.valueLayers = tc.valueLayers[:0]
+	}
+	tc.Context = ctx
+}
+
+// Detach retorna um context para trabalho que continua depois do handler retornar, como uma
+// goroutine de auditoria. Ele parte do context da request, com os valores dela e o request ID,
+// não é cancelado junto com ela e não guarda referência ao tc. Valores adicionados com contexts
+// derivados do próprio tc ficam de fora, já que esses contexts consultam o tc
+func (tc *TupaContext) Detach() context.Context {
+	ctx := tc.layer(0)
+	if len(tc.valueLayers) > 0 {
+		ctx = tc.valueLayers[0]
+	}
+	if ctx.Value(tupaContextKey{}) == tc {
+		ctx = context.Background()
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	if tc.requestID != "" {
+		ctx = context.WithValue(ctx, requestIDKey{}, tc.requestID)
+	}
+	return ctx
+}
+
+// POOL DE CONTEXTOS
+
+// Os TupaContext são reaproveitados entre requests. Por isso o tc, e qualquer context derivado
+// dele, não pode ser guardado nem usado em goroutines depois que o handler retorna: use
+// tc.Detach() ou copie os valores necessários antes
+var tupaContextPool = sync.Pool{
+	New: func() any {
+		return &TupaContext{}
+	},
+}
+
+func acquireTupaContext(server *APIServer, route *RouteInfo, w http.ResponseWriter, r *http.Request) *TupaContext {
+	tc := tupaContextPool.Get().(*TupaContext)
+	tc.reset(server, route, w, r)
+	return tc
+}
+
+// onRelease agenda fn para o fim da request, depois que o dispatcher escreveu o erro
+// retornado pela chain
+func (tc *TupaContext) onRelease(fn func()) {
+	tc.releaseHooks = append(tc.releaseHooks, fn)
+}
+
+func releaseTupaContext(tc *TupaContext) {
+	tc.reset(nil, nil, nil, nil)
+	tupaContextPool.Put(tc)
+}
+
+// reset limpa todos os campos da request anterior, mantendo só as alocações reaproveitáveis
+// (o mapa do store e o slice de hooks do writer)
+func (tc *TupaContext) reset(server *APIServer, route *RouteInfo, w http.ResponseWriter, r *http.Request) {
+	// em ordem inversa, como defers
+	for i := len(tc.releaseHooks) - 1; i >= 0; i-- {
+		tc.releaseHooks[i]()
+	}
+	clear(tc.releaseHooks)
+	tc.releaseHooks = tc.releaseHooks[:0]
+
+	tc.writer.reset(w)
+	if w != nil {
+		tc.response = &tc.writer
+	} else {
+		tc.response = nil
+	}
+
+	clear(tc.valueLayers)
+	tc.valueLayers = tc.valueLayers[:0]
+
+	tc.request = r
+	tc.Context = nil
+	if r != nil {
+		tc.Context = r.Context()
+	}
+
+	tc.server = server
+	tc.route = route
+	tc.requestID = ""
+	tc.userID = ""
+	tc.logger = nil
+	tc.body = nil
+	tc.bodyRead = false
+	tc.bodyLimit = 0
+	tc.aborted = false
+
+	tc.storeMu.Lock()
+	clear(tc.store)
+	tc.storeMu.Unlock()
+}
@@ -9,13 +9,13 @@ func ContrARoutes() []RouteInfo {
 		{
 			Path:        "/contrA",
 			Method:      "GET",
-			Handler:     handleSendString,
+			Handler:     APIFunc(handleSendString),
 			Middlewares: nil,
 		},
 		{
 			Path:        "/contrA",
 			Method:      "POST",
-			Handler:     handleSendString,
+			Handler:     APIFunc(handleSendString),
 			Middlewares: nil,
 		},
 	}
@@ -25,7 +25,10 @@ func AddRoutes(groupMiddlewares MiddlewareChain, routeFuncs ...func() []RouteInf
 	for _, routeFunc := range routeFuncs {
 		routes := routeFunc()
 		for i := range routes {
-			routes[i].Middlewares = append(routes[i].Middlewares, groupMiddlewares...)
+			// middlewares do grupo envolvem os da rota
+			middlewares := make([]Middleware, 0, len(groupMiddlewares)+len(routes[i].Middlewares))
+			middlewares = append(middlewares, groupMiddlewares...)
+			routes[i].Middlewares = append(middlewares, routes[i].Middlewares...)
 		}
 		allRoutes = append(allRoutes, routes...)
 	}
@@ -33,7 +36,7 @@ func AddRoutes(groupMiddlewares MiddlewareChain, routeFuncs ...func() []RouteInf
 
 func PrintRoutess() {
 	for _, route := range allRoutes {
-		fmt.Printf("ROUTEsss => Path: %s, Method: %s, Handler: %v, Middlewares: %v\n", route.Path, route.Method, route.Handler, route.Middlewares)
+		fmt.Printf("ROUTEsss => Path: %s, Method: %s, Handler: %v, Middlewares: %v\n", route.Path, route.Method, route.Handler, MiddlewareChain(route.Middlewares))
 	}
 }
 
@@ -44,11 +47,11 @@ func GetRoutes() []RouteInfo {
 }
 
 func ExampleRouteManager() {
-	AddRoutes(MiddlewareChain{MiddlewareContrA}, ContrARoutes)
+	AddRoutes(MiddlewareChain{MiddlewareFunc(MiddlewareContrA)}, ContrARoutes)
 
-	AddRoutes(MiddlewareChain{MiddlewareContrB}, ContrBRoutes)
+	AddRoutes(MiddlewareChain{MiddlewareFunc(MiddlewareContrB)}, ContrBRoutes)
 
-	AddRoutes(MiddlewareChain{MiddlewareContrC}, ContrCRoutes)
+	AddRoutes(MiddlewareChain{MiddlewareFunc(MiddlewareContrC)}, ContrCRoutes)
 }
 
 func ContrCRoutes() []RouteInfo {
@@ -56,7 +59,7 @@ func ContrCRoutes() []RouteInfo {
 		{
 			Path:    "/contrC",
 			Method:  "POST",
-			Handler: handleSendString,
+			Handler: APIFunc(handleSendString),
 		},
 	}
 }
@@ -5,7 +5,7 @@ func ContrBRoutes() []RouteInfo {
 		{
 			Path:    "/contrB",
 			Method:  "POST",
-			Handler: handleSendString,
+			Handler: APIFunc(handleSendString),
 		},
 	}
 }
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// COOKIES
+
+var (
+	ErrInvalidCookie       = errors.New("cookie inválido ou adulterado")
+	ErrCookieKeyringNotSet = errors.New("nenhum keyring de cookies configurado no servidor, use UseCookieKeyring")
+	ErrCookieSecretTooWeak = errors.New("segredo de cookie precisa ter pelo menos 32 bytes")
+)
+
+// CookieKeyring guarda as chaves usadas para assinar e criptografar cookies.
+// A primeira chave é a atual, usada para gerar novos cookies; as demais só são usadas
+// para ler cookies antigos, permitindo rotacionar segredos sem deslogar ninguém
+type CookieKeyring struct {
+	keys []cookieKey
+}
+
+type cookieKey struct {
+	signKey []byte
+	aead    cipher.AEAD
+}
+
+func NewCookieKeyring(secrets ...[]byte) (*CookieKeyring, error) {
+	if len(secrets) == 0 {
+		return nil, errors.New("keyring de cookies precisa de pelo menos um segredo")
+	}
+
+	keyring := &CookieKeyring{}
+	for _, secret := range secrets {
+		if len(secret) < 32 {
+			return nil, ErrCookieSecretTooWeak
+		}
+
+		// derivando chaves separadas para assinatura e criptografia a partir do mesmo segredo
+		block, err := aes.NewCipher(deriveCookieKey(secret, "tupa-cookie-encrypt"))
+		if err != nil {
+			return nil, err
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		keyring.keys = append(keyring.keys, cookieKey{
+			signKey: deriveCookieKey(secret, "tupa-cookie-sign"),
+			aead:    aead,
+		})
+	}
+
+	return keyring, nil
+}
+
+func deriveCookieKey(secret []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// Sign retorna value com uma assinatura HMAC. O nome do cookie entra na assinatura
+// para que o valor de um cookie não possa ser reaproveitado em outro
+func (k *CookieKeyring) Sign(name, value string) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(value))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(k.keys[0].mac(name, payload))
+}
+
+func (k *CookieKeyring) Verify(name, signed string) (string, error) {
+	payload, sig, ok := strings.Cut(signed, ".")
+	if !ok {
+		return "", ErrInvalidCookie
+	}
+
+	rawSig, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return "", ErrInvalidCookie
+	}
+
+	for _, key := range k.keys {
+		if hmac.Equal(rawSig, key.mac(name, payload)) {
+			value, err := base64.RawURLEncoding.DecodeString(payload)
+			if err != nil {
+				return "", ErrInvalidCookie
+			}
+			return string(value), nil
+		}
+	}
+
+	return "", ErrInvalidCookie
+}
+
+// Encrypt criptografa value com AES-GCM usando o nome do cookie como dado autenticado
+func (k *CookieKeyring) Encrypt(name, value string) (string, error) {
+	aead := k.keys[0].aead
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(name))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (k *CookieKeyring) Decrypt(name, encrypted string) (string, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", ErrInvalidCookie
+	}
+
+	for _, key := range k.keys {
+		nonceSize := key.aead.NonceSize()
+		if len(sealed) < nonceSize {
+			return "", ErrInvalidCookie
+		}
+
+		value, err := key.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(name))
+		if err == nil {
+			return string(value), nil
+		}
+	}
+
+	return "", ErrInvalidCookie
+}
+
+func (key cookieKey) mac(name, payload string) []byte {
+	mac := hmac.New(sha256.New, key.signKey)
+	mac.Write([]byte(name))
+	mac.Write([]byte{'='})
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// UseCookieKeyring configura o keyring usado pelos cookies assinados e criptografados
+func (a *APIServer) UseCookieKeyring(keyring *CookieKeyring) {
+	a.cookieKeyring = keyring
+}
+
+func (tc *TupaContext) cookieKeyring() (*CookieKeyring, error) {
+	if tc.server == nil || tc.server.cookieKeyring == nil {
+		return nil, ErrCookieKeyringNotSet
+	}
+	return tc.server.cookieKeyring, nil
+}
+
+// Cookie retorna o cookie da request com o nome informado
+func (tc *TupaContext) Cookie(name string) (*http.Cookie, error) {
+	return tc.request.Cookie(name)
+}
+
+// SetCookie escreve o cookie na resposta com padrões seguros: HttpOnly, SameSite=Lax,
+// Path=/ e Secure quando o cliente usou HTTPS. Para cookies que precisam ser lidos
+// por JavaScript use http.SetCookie diretamente
+func (tc *TupaContext) SetCookie(cookie *http.Cookie) {
+	c := *cookie
+
+	if c.Path == "" {
+		c.Path = "/"
+	}
+
+	if c.SameSite == 0 || c.SameSite == http.SameSiteDefaultMode {
+		c.SameSite = http.SameSiteLaxMode
+	}
+
+	// navegadores rejeitam SameSite=None sem Secure
+	if tc.IsTLS() || c.SameSite == http.SameSiteNoneMode {
+		c.Secure = true
+	}
+
+	c.HttpOnly = true
+
+	http.SetCookie(tc.response, &c)
+}
+
+// DeleteCookie pede para o navegador remover o cookie
+func (tc *TupaContext) DeleteCookie(name string) {
+	tc.SetCookie(&http.Cookie{
+		Name:    name,
+		Value:   "",
+		MaxAge:  -1,
+		Expires: time.Unix(0, 0),
+	})
+}
+
+// SignedCookie retorna o valor de um cookie escrito por SetSignedCookie, validando a assinatura
+func (tc *TupaContext) SignedCookie(name string) (string, error) {
+	keyring, err := tc.cookieKeyring()
+	if err != nil {
+		return "", err
+	}
+
+	cookie, err := tc.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+
+	return keyring.Verify(name, cookie.Value)
+}
+
+// SetSignedCookie escreve o cookie com o valor assinado. O valor continua legível
+// pelo cliente, mas não pode ser alterado
+func (tc *TupaContext) SetSignedCookie(cookie *http.Cookie) error {
+	keyring, err := tc.cookieKeyring()
+	if err != nil {
+		return err
+	}
+
+	c := *cookie
+	c.Value = keyring.Sign(c.Name, c.Value)
+	tc.SetCookie(&c)
+	return nil
+}
+
+// EncryptedCookie retorna o valor de um cookie escrito por SetEncryptedCookie
+func (tc *TupaContext) EncryptedCookie(name string) (string, error) {
+	keyring, err := tc.cookieKeyring()
+	if err != nil {
+		return "", err
+	}
+
+	cookie, err := tc.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+
+	return keyring.Decrypt(name, cookie.Value)
+}
+
+// SetEncryptedCookie escreve o cookie com o valor criptografado e autenticado
+func (tc *TupaContext) SetEncryptedCookie(cookie *http.Cookie) error {
+	keyring, err := tc.cookieKeyring()
+	if err != nil {
+		return err
+	}
+
+	c := *cookie
+	c.Value, err = keyring.Encrypt(c.Name, c.Value)
+	if err != nil {
+		return err
+	}
+
+	tc.SetCookie(&c)
+	return nil
+}
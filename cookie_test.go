@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestKeyring(t *testing.T, secrets ...[]byte) *CookieKeyring {
+	t.Helper()
+
+	keyring, err := NewCookieKeyring(secrets...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+// roundTripCookie escreve o cookie com set e devolve uma request que carrega ele de volta
+func roundTripCookie(t *testing.T, keyring *CookieKeyring, set func(tc *TupaContext) error) *http.Request {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	tc := newTestContext(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	tc.server.UseCookieKeyring(keyring)
+	if err := set(tc); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, c := range w.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	return req
+}
+
+func TestSetCookieDefaults(t *testing.T) {
+	w := httptest.NewRecorder()
+	tc := (&TupaContext{}).NewTupaContext(w, httptest.NewRequest(http.MethodGet, "https://example.com/", nil))
+
+	tc.SetCookie(&http.Cookie{Name: "tema", Value: "escuro"})
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("esperava 1 cookie, recebeu %d", len(cookies))
+	}
+
+	c := cookies[0]
+	if !c.HttpOnly || !c.Secure || c.SameSite != http.SameSiteLaxMode || c.Path != "/" {
+		t.Errorf("cookie sem os padrões seguros: %+v", c)
+	}
+}
+
+func TestSignedCookie(t *testing.T) {
+	secret := bytes.Repeat([]byte("a"), 32)
+
+	t.Run("Testando cookie assinado ida e volta", func(t *testing.T) {
+		keyring := newTestKeyring(t, secret)
+		req := roundTripCookie(t, keyring, func(tc *TupaContext) error {
+			return tc.SetSignedCookie(&http.Cookie{Name: "state", Value: "xyz"})
+		})
+
+		tc := newTestContext(httptest.NewRecorder(), req)
+		tc.server.UseCookieKeyring(keyring)
+
+		got, err := tc.SignedCookie("state")
+		if err != nil || got != "xyz" {
+			t.Errorf("esperava 'xyz', recebeu '%s' (erro %v)", got, err)
+		}
+	})
+
+	t.Run("Testando cookie assinado adulterado", func(t *testing.T) {
+		keyring := newTestKeyring(t, secret)
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(&http.Cookie{Name: "state", Value: keyring.Sign("state", "xyz") + "a"})
+
+		tc := newTestContext(httptest.NewRecorder(), req)
+		tc.server.UseCookieKeyring(keyring)
+
+		if _, err := tc.SignedCookie("state"); err != ErrInvalidCookie {
+			t.Errorf("esperava ErrInvalidCookie, recebeu %v", err)
+		}
+	})
+
+	t.Run("Testando cookie assinado com outro nome", func(t *testing.T) {
+		keyring := newTestKeyring(t, secret)
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(&http.Cookie{Name: "admin", Value: keyring.Sign("state", "true")})
+
+		tc := newTestContext(httptest.NewRecorder(), req)
+		tc.server.UseCookieKeyring(keyring)
+
+		if _, err := tc.SignedCookie("admin"); err != ErrInvalidCookie {
+			t.Errorf("esperava ErrInvalidCookie, recebeu %v", err)
+		}
+	})
+}
+
+func TestEncryptedCookieRotation(t *testing.T) {
+	oldSecret := bytes.Repeat([]byte("a"), 32)
+	newSecret := bytes.Repeat([]byte("b"), 32)
+
+	oldKeyring := newTestKeyring(t, oldSecret)
+	req := roundTripCookie(t, oldKeyring, func(tc *TupaContext) error {
+		return tc.SetEncryptedCookie(&http.Cookie{Name: "sessao", Value: "usuario-2602"})
+	})
+
+	if c, _ := req.Cookie("sessao"); c.Value == "usuario-2602" {
+		t.Fatal("valor do cookie não deveria estar em texto puro")
+	}
+
+	t.Run("Testando leitura com chave antiga após rotação", func(t *testing.T) {
+		rotated := newTestKeyring(t, newSecret, oldSecret)
+		tc := newTestContext(httptest.NewRecorder(), req)
+		tc.server.UseCookieKeyring(rotated)
+
+		got, err := tc.EncryptedCookie("sessao")
+		if err != nil || got != "usuario-2602" {
+			t.Errorf("esperava 'usuario-2602', recebeu '%s' (erro %v)", got, err)
+		}
+	})
+
+	t.Run("Testando leitura depois da chave antiga ser removida", func(t *testing.T) {
+		onlyNew := newTestKeyring(t, newSecret)
+		tc := newTestContext(httptest.NewRecorder(), req)
+		tc.server.UseCookieKeyring(onlyNew)
+
+		if _, err := tc.EncryptedCookie("sessao"); err != ErrInvalidCookie {
+			t.Errorf("esperava ErrInvalidCookie, recebeu %v", err)
+		}
+	})
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ERROS HTTP
+
+// HTTPError é um erro com status HTTP que middlewares e handlers podem retornar. Message,
+// Code e Details vão para o cliente; Err é a causa interna, que só aparece nos logs
+//
+//	if user == nil {
+//		return NotFound("usuário não encontrado").WithCode("user_not_found")
+//	}
+//	if err != nil {
+//		return InternalError(err)
+//	}
+type HTTPError struct {
+	Status  int
+	Code    string
+	Message string
+	Details any
+	Err     error
+	Header  http.Header
+}
+
+// NewHTTPError cria um erro com status e mensagem para o cliente. Sem mensagem é
+// usado o texto padrão do status
+func NewHTTPError(status int, message string) *HTTPError {
+	return &HTTPError{Status: status, Message: message}
+}
+
+func BadRequest(message string) *HTTPError {
+	return NewHTTPError(http.StatusBadRequest, message)
+}
+
+// Unauthorized cria um 401. O challenge, como `Bearer realm="api"`, vai no header WWW-Authenticate
+func Unauthorized(message, challenge string) *HTTPError {
+	err := NewHTTPError(http.StatusUnauthorized, message)
+	if challenge != "" {
+		err = err.WithHeader("WWW-Authenticate", challenge)
+	}
+	return err
+}
+
+func Forbidden(message string) *HTTPError {
+	return NewHTTPError(http.StatusForbidden, message)
+}
+
+func NotFound(message string) *HTTPError {
+	return NewHTTPError(http.StatusNotFound, message)
+}
+
+func Conflict(message string) *HTTPError {
+	return NewHTTPError(http.StatusConflict, message)
+}
+
+// TooManyRequests cria um 429 com o header Retry-After em segundos
+func TooManyRequests(message string, retryAfter time.Duration) *HTTPError {
+	err := NewHTTPError(http.StatusTooManyRequests, message)
+	if retryAfter > 0 {
+		seconds := int((retryAfter + time.Second - 1) / time.Second)
+		err = err.WithHeader("Retry-After", strconv.Itoa(seconds))
+	}
+	return err
+}
+
+// InternalError cria um 500 com cause como causa interna. O cliente só vê a mensagem genérica
+func InternalError(cause error) *HTTPError {
+	return &HTTPError{Status: http.StatusInternalServerError, Err: cause}
+}
+
+func (e *HTTPError) Error() string {
+	msg := e.clientMessage()
+	if e.Err != nil {
+		return msg + ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+func (e *HTTPError) StatusCode() int {
+	if e.Status == 0 {
+		return http.StatusInternalServerError
+	}
+	return e.Status
+}
+
+func (e *HTTPError) clientMessage() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return http.StatusText(e.StatusCode())
+}
+
+// Os métodos With* retornam uma cópia, então erros em variáveis de pacote
+// podem ser usados como base sem risco de alterar o original
+
+func (e *HTTPError) WithCode(code string) *HTTPError {
+	c := e.clone()
+	c.Code = code
+	return c
+}
+
+func (e *HTTPError) WithDetails(details any) *HTTPError {
+	c := e.clone()
+	c.Details = details
+	return c
+}
+
+// Wrap guarda cause como causa interna do erro
+func (e *HTTPError) Wrap(cause error) *HTTPError {
+	c := e.clone()
+	c.Err = cause
+	return c
+}
+
+func (e *HTTPError) WithHeader(key, value string) *HTTPError {
+	c := e.clone()
+	c.Header.Add(key, value)
+	return c
+}
+
+func (e *HTTPError) clone() *HTTPError {
+	c := *e
+	if e.Header != nil {
+		c.Header = e.Header.Clone()
+	} else {
+		c.Header = http.Header{}
+	}
+	return &c
+}
+
+// errorStatus escolhe o status HTTP para um erro retornado por middleware ou handler
+func errorStatus(err error) int {
+	var coder StatusCoder
+	var bindErr *BindError
+	var validationErr *ValidationError
+
+	switch {
+	case errors.As(err, &coder):
+		return coder.StatusCode()
+	case errors.As(err, &bindErr):
+		return http.StatusBadRequest
+	case errors.As(err, &validationErr):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// toAPIError monta o body de erro enviado ao cliente. A mensagem de err.Error() pode carregar
+// detalhes internos (queries, caminhos, endereços) de causas embrulhadas, então o cliente recebe
+// a mensagem do HTTPError ou o texto do status. Só erros de bind e validação, que descrevem o
+// input do próprio cliente, vão como estão
+func toAPIError(err error, status int) (APIError, http.Header) {
+	var httpErr *HTTPError
+	hasHTTPErr := errors.As(err, &httpErr)
+	if hasHTTPErr && httpErr.StatusCode() == status {
+		return APIError{Error: httpErr.clientMessage(), Code: httpErr.Code, Details: httpErr.Details}, httpErr.Header
+	}
+
+	if status >= http.StatusInternalServerError {
+		return APIError{Error: http.StatusText(status)}, nil
+	}
+
+	var bindErr *BindError
+	var validationErr *ValidationError
+	switch {
+	case hasHTTPErr && httpErr.Message != "":
+		return APIError{Error: httpErr.Message}, nil
+	case errors.As(err, &bindErr), errors.As(err, &validationErr):
+		return APIError{Error: err.Error()}, nil
+	default:
+		return APIError{Error: http.StatusText(status)}, nil
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func decodeAPIError(t *testing.T, w *httptest.ResponseRecorder) APIError {
+	t.Helper()
+
+	var body APIError
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("body de erro inválido: %v", err)
+	}
+	return body
+}
+
+func TestHTTPError(t *testing.T) {
+	serveError := func(err error) *httptest.ResponseRecorder {
+		route := RouteInfo{Path: "/erro", Method: MethodGet, Handler: APIFunc(func(tc *TupaContext) error {
+			return err
+		})}
+		return serveRoute(route, httptest.NewRequest(http.MethodGet, "/erro", nil))
+	}
+
+	t.Run("Testando 401 com WWW-Authenticate vindo de middleware", func(t *testing.T) {
+		auth := MiddlewareFunc(func(next APIFunc) APIFunc {
+			return func(tc *TupaContext) error {
+				if tc.Request().Header.Get("Authorization") == "" {
+					return Unauthorized("token ausente", `Bearer realm="api"`)
+				}
+				return next(tc)
+			}
+		})
+
+		route := RouteInfo{Path: "/privado", Method: MethodGet, Handler: APIFunc(handleSendString)}
+		w := serveRoute(route, httptest.NewRequest(http.MethodGet, "/privado", nil), auth)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("status errado: recebeu %d queria %d", w.Code, http.StatusUnauthorized)
+		}
+		if got := w.Header().Get("WWW-Authenticate"); got != `Bearer realm="api"` {
+			t.Errorf("WWW-Authenticate errado: %q", got)
+		}
+		if body := decodeAPIError(t, w); body.Error != "token ausente" {
+			t.Errorf("mensagem errada: %q", body.Error)
+		}
+	})
+
+	t.Run("Testando código, detalhes e erro embrulhado", func(t *testing.T) {
+		err := fmt.Errorf("buscando gato: %w", NotFound("gato não encontrado").
+			WithCode("cat_not_found").
+			WithDetails(map[string]string{"id": "42"}))
+
+		w := serveError(err)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("status errado: recebeu %d queria %d", w.Code, http.StatusNotFound)
+		}
+
+		body := decodeAPIError(t, w)
+		if body.Error != "gato não encontrado" || body.Code != "cat_not_found" {
+			t.Errorf("body errado: %+v", body)
+		}
+		if details, ok := body.Details.(map[string]any); !ok || details["id"] != "42" {
+			t.Errorf("detalhes errados: %v", body.Details)
+		}
+	})
+
+	t.Run("Testando Retry-After", func(t *testing.T) {
+		w := serveError(TooManyRequests("calma", 1500*time.Millisecond))
+
+		if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "2" {
+			t.Errorf("esperava 429 com Retry-After 2, recebeu %d %q", w.Code, w.Header().Get("Retry-After"))
+		}
+	})
+
+	t.Run("Testando que detalhes internos não vão para o cliente", func(t *testing.T) {
+		for _, err := range []error{
+			errors.New("pq: senha incorreta para usuário postgres"),
+			InternalError(errors.New("pq: senha incorreta para usuário postgres")),
+		} {
+			w := serveError(err)
+
+			if w.Code != http.StatusInternalServerError {
+				t.Errorf("status errado: recebeu %d queria %d", w.Code, http.StatusInternalServerError)
+			}
+			if strings.Contains(w.Body.String(), "postgres") {
+				t.Errorf("body vazou o erro interno: %s", w.Body.String())
+			}
+		}
+	})
+
+	t.Run("Testando que a causa não vaza quando outro erro define o status", func(t *testing.T) {
+		cause := errors.New("pq: senha incorreta para usuário postgres")
+		tests := map[error]string{
+			teapotError{InternalError(cause)}:                        http.StatusText(http.StatusTeapot),
+			teapotError{NotFound("gato não encontrado").Wrap(cause)}: "gato não encontrado",
+			teapotError{cause}: http.StatusText(http.StatusTeapot),
+		}
+
+		for err, want := range tests {
+			w := serveError(err)
+
+			if w.Code != http.StatusTeapot {
+				t.Errorf("status errado: recebeu %d queria %d", w.Code, http.StatusTeapot)
+			}
+			if body := decodeAPIError(t, w); body.Error != want {
+				t.Errorf("mensagem errada: recebeu %q queria %q", body.Error, want)
+			}
+		}
+	})
+
+	t.Run("Testando que With* não altera o erro original", func(t *testing.T) {
+		base := Forbidden("sem acesso")
+		derived := base.WithCode("forbidden").WithHeader("X-Motivo", "plano")
+
+		if base.Code != "" || base.Header.Get("X-Motivo") != "" {
+			t.Errorf("erro base foi alterado: %+v", base)
+		}
+		if derived.Code != "forbidden" || derived.Header.Get("X-Motivo") != "plano" {
+			t.Errorf("erro derivado errado: %+v", derived)
+		}
+	})
+
+	t.Run("Testando errors.Is e errors.As com a causa", func(t *testing.T) {
+		cause := errors.New("timeout no banco")
+		err := InternalError(cause)
+
+		if !errors.Is(err, cause) {
+			t.Errorf("errors.Is deveria achar a causa")
+		}
+
+		var httpErr *HTTPError
+		if !errors.As(fmt.Errorf("contexto: %w", err), &httpErr) || httpErr.StatusCode() != http.StatusInternalServerError {
+			t.Errorf("errors.As deveria achar o HTTPError")
+		}
+	})
+}
+
+// teapotError define o próprio status em volta de outro erro, como GuardError faz
+type teapotError struct {
+	err error
+}
+
+func (e teapotError) Error() string   { return "bule: " + e.err.Error() }
+func (e teapotError) Unwrap() error   { return e.err }
+func (e teapotError) StatusCode() int { return http.StatusTeapot }
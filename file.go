@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ARQUIVOS
+
+// tipos que não estão na tabela padrão do Go e nem sempre estão no mime.types do sistema
+var extraContentTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".webm": "video/webm",
+	".mov":  "video/quicktime",
+	".mp3":  "audio/mpeg",
+	".heic": "image/heic",
+	".heif": "image/heif",
+}
+
+// File envia o arquivo em path. Suporta requests com Range (inclusive múltiplos intervalos),
+// ETag, If-None-Match, If-Modified-Since e If-Range. Se o handler já tiver setado um ETag
+// (forte ou fraco, W/"..."), ele é usado nas comparações no lugar do gerado
+func (tc *TupaContext) File(path string) error {
+	return tc.sendFile(path, "")
+}
+
+// sendFile envia o arquivo em path com o Content-Disposition disposition, setado só depois
+// que o arquivo abriu: um erro ao abrir vira um APIError comum e não um download
+func (tc *TupaContext) sendFile(path, disposition string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fileError(path, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		return NotFound("arquivo não encontrado").Wrap(fmt.Errorf("%s é um diretório", path))
+	}
+
+	if disposition != "" {
+		tc.response.Header().Set("Content-Disposition", disposition)
+	}
+	return tc.serveContent(info.Name(), info.ModTime(), f)
+}
+
+// FileFS envia o arquivo name do sistema de arquivos fsys. Nomes com ".." ou caminhos
+// absolutos são rejeitados pelo próprio fs.FS, então é seguro usar com parâmetros da rota
+func (tc *TupaContext) FileFS(fsys fs.FS, name string) error {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return fileError(name, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		return NotFound("arquivo não encontrado").Wrap(fmt.Errorf("%s é um diretório", name))
+	}
+
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		// sem Seek não dá para atender Range, então lemos o arquivo para a memória
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return err
+		}
+		content = bytes.NewReader(data)
+	}
+
+	return tc.serveContent(info.Name(), info.ModTime(), content)
+}
+
+// Attachment envia o arquivo pedindo para o navegador baixar com o nome downloadName,
+// que pode conter caracteres não ASCII
+func (tc *TupaContext) Attachment(path, downloadName string) error {
+	return tc.sendFile(path, contentDisposition("attachment", downloadName))
+}
+
+func (tc *TupaContext) serveContent(name string, modTime time.Time, content io.ReadSeeker) error {
+	header := tc.response.Header()
+
+	if header.Get("Content-Type") == "" {
+		if ct := contentTypeByExtension(name); ct != "" {
+			header.Set("Content-Type", ct)
+		}
+	}
+
+	if header.Get("ETag") == "" {
+		etag, err := fileETag(modTime, content)
+		if err != nil {
+			return err
+		}
+		header.Set("ETag", etag)
+	}
+
+	http.ServeContent(tc.response, tc.request, name, modTime, content)
+	return nil
+}
+
+func contentTypeByExtension(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	if ct, ok := extraContentTypes[ext]; ok {
+		return ct
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// fileETag gera um ETag forte a partir da data de modificação e do tamanho. Arquivos sem
+// data de modificação (embed.FS, por exemplo) usam o hash do conteúdo
+func fileETag(modTime time.Time, content io.ReadSeeker) (string, error) {
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	if !modTime.IsZero() {
+		return fmt.Sprintf(`"%x-%x"`, modTime.UnixNano(), size), nil
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return "", err
+	}
+
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	return `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`, nil
+}
+
+// contentDisposition monta o header com um filename ASCII para clientes antigos
+// e o filename* da RFC 6266/5987 com o nome original em UTF-8
+func contentDisposition(dispositionType, filename string) string {
+	var fallback strings.Builder
+	ascii := true
+	for _, r := range filename {
+		switch {
+		case r == '"' || r == '\\' || r < 0x20 || r == 0x7f:
+			fallback.WriteByte('_')
+		case r > 0x7f:
+			fallback.WriteByte('_')
+			ascii = false
+		default:
+			fallback.WriteRune(r)
+		}
+	}
+
+	value := fmt.Sprintf(`%s; filename="%s"`, dispositionType, fallback.String())
+	if !ascii {
+		value += "; filename*=UTF-8''" + encodeRFC5987(filename)
+	}
+	return value
+}
+
+func encodeRFC5987(s string) string {
+	const attrChars = "!#$&+-.^_`|~"
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') || strings.IndexByte(attrChars, c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func fileError(name string, err error) error {
+	switch {
+	// fs.FS retorna fs.ErrInvalid para nomes como "../x", que tratamos como não encontrado
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, fs.ErrInvalid):
+		return NotFound("arquivo não encontrado").Wrap(err)
+	case errors.Is(err, fs.ErrPermission):
+		return Forbidden("sem permissão para ler o arquivo").Wrap(err)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+const sampleVideo = "file_example_MP4_480_1_5MG.mp4"
+
+var staticImages = os.DirFS("static/images")
+
+func HandleStaticImage(tc *TupaContext) error {
+	return tc.FileFS(staticImages, tc.Param("name"))
+}
+
+func HandleSampleVideo(tc *TupaContext) error {
+	return tc.File(sampleVideo)
+}
+
+func HandleDownloadImage(tc *TupaContext) error {
+	name := filepath.Base(tc.Param("name"))
+	return tc.Attachment(filepath.Join("static/images", name), "imagem-tupã-"+name)
+}
+
+func ContrFileRoutes() []RouteInfo {
+	return []RouteInfo{
+		{
+			Path:    "/static/images/{name}",
+			Method:  "GET",
+			Handler: APIFunc(HandleStaticImage),
+		},
+		{
+			Path:    "/download/images/{name}",
+			Method:  "GET",
+			Handler: APIFunc(HandleDownloadImage),
+		},
+		{
+			Path:    "/video",
+			Method:  "GET",
+			Handler: APIFunc(HandleSampleVideo),
+		},
+	}
+}
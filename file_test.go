@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "video.mp4")
+	if err := os.WriteFile(path, []byte("0123456789"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	route := RouteInfo{Path: "/video", Method: MethodGet, Handler: APIFunc(func(tc *TupaContext) error {
+		return tc.File(path)
+	})}
+
+	t.Run("Testando arquivo completo", func(t *testing.T) {
+		w := serveRoute(route, httptest.NewRequest(http.MethodGet, "/video", nil))
+
+		if w.Code != http.StatusOK || w.Body.String() != "0123456789" {
+			t.Errorf("resposta inesperada: %d %q", w.Code, w.Body.String())
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "video/mp4" {
+			t.Errorf("esperava video/mp4, recebeu %s", ct)
+		}
+		if w.Header().Get("ETag") == "" || w.Header().Get("Accept-Ranges") != "bytes" {
+			t.Errorf("esperava ETag e Accept-Ranges: %v", w.Header())
+		}
+	})
+
+	t.Run("Testando Range", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/video", nil)
+		req.Header.Set("Range", "bytes=2-4")
+		w := serveRoute(route, req)
+
+		if w.Code != http.StatusPartialContent || w.Body.String() != "234" {
+			t.Errorf("esperava 206 com '234', recebeu %d %q", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("Testando múltiplos intervalos", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/video", nil)
+		req.Header.Set("Range", "bytes=0-1,8-9")
+		w := serveRoute(route, req)
+
+		if !strings.HasPrefix(w.Header().Get("Content-Type"), "multipart/byteranges") {
+			t.Errorf("esperava multipart/byteranges, recebeu %s", w.Header().Get("Content-Type"))
+		}
+	})
+
+	t.Run("Testando If-None-Match", func(t *testing.T) {
+		etag := serveRoute(route, httptest.NewRequest(http.MethodGet, "/video", nil)).Header().Get("ETag")
+
+		req := httptest.NewRequest(http.MethodGet, "/video", nil)
+		req.Header.Set("If-None-Match", "W/"+etag)
+		w := serveRoute(route, req)
+
+		if w.Code != http.StatusNotModified {
+			t.Errorf("esperava 304, recebeu %d", w.Code)
+		}
+	})
+
+	t.Run("Testando If-Range com ETag antigo", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/video", nil)
+		req.Header.Set("Range", "bytes=2-4")
+		req.Header.Set("If-Range", `"desatualizado"`)
+		w := serveRoute(route, req)
+
+		if w.Code != http.StatusOK || w.Body.Len() != 10 {
+			t.Errorf("esperava arquivo completo, recebeu %d com %d bytes", w.Code, w.Body.Len())
+		}
+	})
+
+	t.Run("Testando arquivo inexistente", func(t *testing.T) {
+		w := serveRoute(RouteInfo{Path: "/video", Method: MethodGet, Handler: APIFunc(func(tc *TupaContext) error {
+			return tc.File(filepath.Join(dir, "nao-existe.mp4"))
+		})}, httptest.NewRequest(http.MethodGet, "/video", nil))
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("esperava 404, recebeu %d", w.Code)
+		}
+	})
+
+	t.Run("Testando anexo inexistente", func(t *testing.T) {
+		w := serveRoute(RouteInfo{Path: "/video", Method: MethodGet, Handler: APIFunc(func(tc *TupaContext) error {
+			return tc.Attachment(filepath.Join(dir, "nao-existe.mp4"), "video.mp4")
+		})}, httptest.NewRequest(http.MethodGet, "/video", nil))
+
+		if w.Code != http.StatusNotFound || w.Header().Get("Content-Disposition") != "" {
+			t.Errorf("o erro não deveria virar download: %d %q", w.Code, w.Header().Get("Content-Disposition"))
+		}
+	})
+
+	t.Run("Testando anexo", func(t *testing.T) {
+		w := serveRoute(RouteInfo{Path: "/video", Method: MethodGet, Handler: APIFunc(func(tc *TupaContext) error {
+			return tc.Attachment(path, "vídeo.mp4")
+		})}, httptest.NewRequest(http.MethodGet, "/video", nil))
+
+		if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Disposition"), "attachment;") {
+			t.Errorf("esperava o arquivo como anexo: %d %q", w.Code, w.Header().Get("Content-Disposition"))
+		}
+	})
+}
+
+func TestFileFS(t *testing.T) {
+	fsys := fstest.MapFS{"foto.heic": {Data: []byte("heic")}}
+
+	t.Run("Testando arquivo sem data de modificação", func(t *testing.T) {
+		w := serveRoute(RouteInfo{Path: "/", Method: MethodGet, Handler: APIFunc(func(tc *TupaContext) error {
+			return tc.FileFS(fsys, "foto.heic")
+		})}, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/heic" || w.Header().Get("ETag") == "" {
+			t.Errorf("resposta inesperada: %d %v", w.Code, w.Header())
+		}
+	})
+
+	t.Run("Testando caminho fora do fs", func(t *testing.T) {
+		w := serveRoute(RouteInfo{Path: "/", Method: MethodGet, Handler: APIFunc(func(tc *TupaContext) error {
+			return tc.FileFS(fsys, "../main.go")
+		})}, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("esperava 404, recebeu %d", w.Code)
+		}
+	})
+}
+
+func TestContentDisposition(t *testing.T) {
+	got := contentDisposition("attachment", `relatório "final".pdf`)
+	want := `attachment; filename="relat_rio _final_.pdf"; filename*=UTF-8''relat%C3%B3rio%20%22final%22.pdf`
+
+	if got != want {
+		t.Errorf("esperava %s, recebeu %s", want, got)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GUARDS
+
+// Guard é uma verificação independente que pode rodar em paralelo com outras, como
+// consultar a cota e as permissões do usuário. Check roda em outra goroutine: ele pode ler
+// a request, o store (tc.Get/tc.Set são seguros) e o logger, mas não deve escrever na resposta.
+// Check deve respeitar o ctx recebido, que é cancelado no timeout e na falha de outro guard
+type Guard struct {
+	Name string
+	// Timeout limita a duração do Check. Zero usa só o prazo da request
+	Timeout time.Duration
+	Check   func(ctx context.Context, tc *TupaContext) error
+}
+
+// GuardError junta os erros dos guards que falharam. O primeiro da lista é o que falhou
+// primeiro e define o status da resposta
+type GuardError struct {
+	Errors []error
+}
+
+func (e *GuardError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e *GuardError) Unwrap() []error {
+	return e.Errors
+}
+
+func (e *GuardError) StatusCode() int {
+	return errorStatus(e.Errors[0])
+}
+
+// Guards cria um middleware que roda os guards em paralelo antes do handler. Na primeira
+// falha o context dos outros é cancelado e a request para com um *GuardError
+//
+//	Middlewares: []Middleware{Guards(
+//		Guard{Name: "cota", Timeout: 200 * time.Millisecond, Check: checkQuota},
+//		Guard{Name: "permissão", Check: checkPermission},
+//	)},
+func Guards(guards ...Guard) *NamedMiddleware {
+	return Named("guards", func(next APIFunc) APIFunc {
+		return func(tc *TupaContext) error {
+			if err := runGuards(tc, guards); err != nil {
+				return err
+			}
+			return next(tc)
+		}
+	})
+}
+
+func runGuards(tc *TupaContext, guards []Guard) error {
+	if len(guards) == 0 {
+		return nil
+	}
+
+	// criando o logger antes das goroutines, já que ele é montado na primeira chamada
+	tc.Logger()
+
+	ctx, cancel := context.WithCancel(tc)
+	defer cancel()
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed []error
+	)
+
+	for _, guard := range guards {
+		wg.Add(1)
+		go func(guard Guard) {
+			defer wg.Done()
+
+			err := runGuard(ctx, tc, guard)
+			if err == nil {
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			// guards interrompidos pela falha de outro não são erros de verdade
+			if len(failed) > 0 && errors.Is(err, context.Canceled) {
+				return
+			}
+			failed = append(failed, err)
+			cancel()
+		}(guard)
+	}
+
+	wg.Wait()
+
+	if len(failed) == 0 {
+		return nil
+	}
+	return &GuardError{Errors: failed}
+}
+
+func runGuard(ctx context.Context, tc *TupaContext, guard Guard) (err error) {
+	if guard.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, guard.Timeout)
+		defer cancel()
+	}
+
+	// um panic numa goroutine derrubaria o processo inteiro
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("guard %s: panic: %v", guard.Name, r)
+		}
+	}()
+
+	err = guard.Check(ctx, tc)
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) && tc.Err() == nil {
+		return NewHTTPError(http.StatusServiceUnavailable, "").
+			Wrap(fmt.Errorf("guard %s excedeu o tempo limite de %s: %w", guard.Name, guard.Timeout, err))
+	}
+
+	return fmt.Errorf("guard %s: %w", guard.Name, err)
+}
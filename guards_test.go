@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGuards(t *testing.T) {
+	var called bool
+	guardedRoute := func(guards ...Guard) RouteInfo {
+		called = false
+		return RouteInfo{
+			Path:        "/guards",
+			Method:      MethodGet,
+			Middlewares: []Middleware{Guards(guards...)},
+			Handler: APIFunc(func(tc *TupaContext) error {
+				called = true
+				return tc.SendString("ok")
+			}),
+		}
+	}
+
+	t.Run("Testando guards rodando em paralelo", func(t *testing.T) {
+		// cada guard só termina quando o outro começou, então em sequência o teste travaria
+		started := make(chan struct{}, 2)
+		waitOther := func(ctx context.Context, tc *TupaContext) error {
+			started <- struct{}{}
+			deadline := time.After(time.Second)
+			for len(started) < 2 {
+				select {
+				case <-deadline:
+					return errors.New("guards não rodaram em paralelo")
+				case <-time.After(time.Millisecond):
+				}
+			}
+			return nil
+		}
+
+		w := serveRoute(guardedRoute(
+			Guard{Name: "cota", Check: waitOther},
+			Guard{Name: "permissão", Check: waitOther},
+		), httptest.NewRequest(http.MethodGet, "/guards", nil))
+
+		if !called || w.Code != http.StatusOK {
+			t.Errorf("handler deveria ser chamado com 200, recebeu %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("Testando cancelamento na primeira falha", func(t *testing.T) {
+		var canceled atomic.Bool
+		w := serveRoute(guardedRoute(
+			Guard{Name: "permissão", Check: func(ctx context.Context, tc *TupaContext) error {
+				return Forbidden("sem permissão")
+			}},
+			Guard{Name: "cota", Check: func(ctx context.Context, tc *TupaContext) error {
+				select {
+				case <-ctx.Done():
+					canceled.Store(true)
+					return ctx.Err()
+				case <-time.After(time.Second):
+					return nil
+				}
+			}},
+		), httptest.NewRequest(http.MethodGet, "/guards", nil))
+
+		if called {
+			t.Errorf("handler não deveria ser chamado")
+		}
+		if !canceled.Load() {
+			t.Errorf("guard lento deveria ser cancelado")
+		}
+		if w.Code != http.StatusForbidden {
+			t.Errorf("status errado: recebeu %d queria %d", w.Code, http.StatusForbidden)
+		}
+	})
+
+	t.Run("Testando agregação de erros", func(t *testing.T) {
+		errA := errors.New("falha a")
+		errB := errors.New("falha b")
+		tc := &TupaContext{request: httptest.NewRequest(http.MethodGet, "/", nil)}
+
+		err := runGuards(tc, []Guard{
+			{Name: "a", Check: func(ctx context.Context, tc *TupaContext) error { return errA }},
+			{Name: "b", Check: func(ctx context.Context, tc *TupaContext) error { return errB }},
+		})
+
+		var guardErr *GuardError
+		if !errors.As(err, &guardErr) {
+			t.Fatalf("esperava *GuardError, recebeu %v", err)
+		}
+		if len(guardErr.Errors) != 2 || !errors.Is(err, errA) || !errors.Is(err, errB) {
+			t.Errorf("esperava os dois erros agregados, recebeu %v", guardErr.Errors)
+		}
+	})
+
+	t.Run("Testando timeout por guard", func(t *testing.T) {
+		w := serveRoute(guardedRoute(Guard{
+			Name:    "lento",
+			Timeout: 10 * time.Millisecond,
+			Check: func(ctx context.Context, tc *TupaContext) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		}), httptest.NewRequest(http.MethodGet, "/guards", nil))
+
+		if called {
+			t.Errorf("handler não deveria ser chamado")
+		}
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("status errado: recebeu %d queria %d", w.Code, http.StatusServiceUnavailable)
+		}
+	})
+
+	t.Run("Testando panic em guard", func(t *testing.T) {
+		w := serveRoute(guardedRoute(Guard{Name: "quebrado", Check: func(ctx context.Context, tc *TupaContext) error {
+			panic("boom")
+		}}), httptest.NewRequest(http.MethodGet, "/guards", nil))
+
+		if called || w.Code != http.StatusInternalServerError {
+			t.Errorf("panic deveria virar 500 sem chamar o handler, recebeu %d", w.Code)
+		}
+	})
+}
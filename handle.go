@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// HANDLERS TIPADOS
+
+// Validator é implementado por inputs que sabem se validar. Handle chama Validate
+// depois do bind e responde 422 se ele retornar erro
+type Validator interface {
+	Validate() error
+}
+
+// StatusCoder permite que erros e respostas definam o status HTTP enviado ao cliente
+type StatusCoder interface {
+	StatusCode() int
+}
+
+// ValidationError indica que o input foi lido corretamente mas não passou na validação
+type ValidationError struct {
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	return "validação falhou: " + e.Err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// HandlerTypes guarda os tipos de entrada e saída de um handler criado por Handle,
+// para geração de documentação e de clientes
+type HandlerTypes struct {
+	Request  reflect.Type
+	Response reflect.Type
+}
+
+// TypedHandler é o handler criado por Handle. No registro da rota os tipos dele preenchem
+// RouteInfo.RequestType e RouteInfo.ResponseType
+type TypedHandler struct {
+	Types HandlerTypes
+	serve APIFunc
+}
+
+func (h *TypedHandler) ServeTupa(tc *TupaContext) error {
+	return h.serve(tc)
+}
+
+// TypesOf retorna os tipos do handler, se ele foi criado por Handle
+func TypesOf(handler Handler) (HandlerTypes, bool) {
+	typed, ok := handler.(*TypedHandler)
+	if !ok || typed == nil {
+		return HandlerTypes{}, false
+	}
+	return typed.Types, true
+}
+
+// Handle adapta uma função tipada para um Handler. Req é preenchido com o body (JSON, XML ou form),
+// a query string (tag `query`) e os parâmetros da rota (tag `path`), nessa ordem de prioridade
+// crescente, validado e passado para fn. Res é serializado conforme o header Accept
+//
+//	Handler: Handle(func(ctx context.Context, req CreateUserRequest) (User, error) {
+//		return users.Create(ctx, req)
+//	}),
+func Handle[Req, Res any](fn func(ctx context.Context, req Req) (Res, error)) *TypedHandler {
+	handler := func(tc *TupaContext) error {
+		var req Req
+		if err := bindRequest(tc, &req); err != nil {
+			return err
+		}
+
+		if err := validateRequest(&req); err != nil {
+			return err
+		}
+
+		res, err := fn(tc, req)
+		if err != nil {
+			return err
+		}
+
+		status := http.StatusOK
+		if coder, ok := any(res).(StatusCoder); ok {
+			status = coder.StatusCode()
+		}
+
+		return tc.Negotiate(status, res)
+	}
+
+	return &TypedHandler{
+		Types: HandlerTypes{
+			Request:  reflect.TypeOf((*Req)(nil)).Elem(),
+			Response: reflect.TypeOf((*Res)(nil)).Elem(),
+		},
+		serve: handler,
+	}
+}
+
+func bindRequest(tc *TupaContext, dst any) error {
+	target := reflect.ValueOf(dst).Elem()
+	if target.Kind() == reflect.Pointer {
+		target.Set(reflect.New(target.Type().Elem()))
+		dst = target.Interface()
+		target = target.Elem()
+	}
+
+	if target.Kind() == reflect.Struct {
+		if err := applyDefaults(target); err != nil {
+			return err
+		}
+	}
+
+	if err := bindBody(tc, dst); err != nil {
+		return err
+	}
+
+	if target.Kind() != reflect.Struct {
+		return nil
+	}
+
+	// query e path só preenchem os campos marcados com a tag delas, para não sobrescrever o body
+	if err := bindValues(tc.request.URL.Query(), dst, "query", bindOptions{tagged: true}); err != nil {
+		return err
+	}
+
+	vars := mux.Vars(tc.request)
+	pathValues := make(map[string][]string, len(vars))
+	for k, v := range vars {
+		pathValues[k] = []string{v}
+	}
+
+	return bindValues(pathValues, dst, "path", bindOptions{tagged: true})
+}
+
+func bindBody(tc *TupaContext, dst any) error {
+	r := tc.request
+	if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+		return nil
+	}
+
+	body, err := tc.Body()
+	if err != nil || len(body) == 0 {
+		return err
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch mediaType {
+	case "application/xml", "text/xml":
+		err = xml.Unmarshal(body, dst)
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		if err = r.ParseMultipartForm(32 << 20); errors.Is(err, http.ErrNotMultipart) {
+			err = nil
+		}
+		if err == nil && reflect.ValueOf(dst).Elem().Kind() == reflect.Struct {
+			err = bindValues(r.PostForm, dst, "form", bindOptions{})
+		}
+	default:
+		err = json.Unmarshal(body, dst)
+	}
+
+	if err != nil {
+		var bindErr *BindError
+		if errors.As(err, &bindErr) {
+			return err
+		}
+		return &BindError{Field: "body", Err: err}
+	}
+
+	return nil
+}
+
+func validateRequest(req any) error {
+	validator, ok := req.(Validator)
+	if !ok {
+		validator, ok = reflect.ValueOf(req).Elem().Interface().(Validator)
+	}
+
+	if !ok {
+		return nil
+	}
+
+	if err := validator.Validate(); err != nil {
+		return &ValidationError{Err: err}
+	}
+	return nil
+}
+
+// NEGOCIAÇÃO DE CONTEÚDO
+
+// Negotiate serializa v no formato preferido pelo header Accept da request.
+// Suporta JSON (padrão), XML e texto puro. Se o cliente não aceitar nenhum deles retorna 406
+func (tc *TupaContext) Negotiate(status int, v any) error {
+	switch negotiateContentType(tc.request.Header.Get("Accept"), "application/json", "application/xml", "text/plain") {
+	case "":
+		return NewHTTPError(http.StatusNotAcceptable, "Nenhum formato aceito: use application/json, application/xml ou text/plain")
+
+	case "application/xml":
+		tc.response.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		tc.response.WriteHeader(status)
+		return xml.NewEncoder(tc.response).Encode(v)
+
+	case "text/plain":
+		tc.response.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		tc.response.WriteHeader(status)
+		_, err := fmt.Fprint(tc.response, v)
+		return err
+
+	default:
+		return WriteJSONHelper(tc.response, status, v)
+	}
+}
+
+// negotiateContentType escolhe entre offers o tipo com maior q no header Accept. Cada oferta usa
+// o q da faixa mais específica que casa com ela, então "application/json;q=0, */*" recusa JSON.
+// Sem Accept retorna o primeiro oferecido; sem nenhum tipo com q>0 aceitável, retorna ""
+func negotiateContentType(accept string, offers ...string) string {
+	if accept == "" {
+		return offers[0]
+	}
+
+	type mediaRange struct {
+		mediaType string
+		q         float64
+	}
+
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if raw, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(raw, 64); err == nil {
+				q = parsed
+			}
+		}
+		ranges = append(ranges, mediaRange{mediaType: mediaType, q: q})
+	}
+
+	// specificity: 3 para o tipo exato, 2 para "tipo/*" e 1 para "*/*"
+	specificity := func(mediaType, offer string) int {
+		switch {
+		case mediaType == offer:
+			return 3
+		case strings.HasSuffix(mediaType, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(mediaType, "*")):
+			return 2
+		case mediaType == "*/*":
+			return 1
+		}
+		return 0
+	}
+
+	best, bestQ, bestPos := "", 0.0, len(ranges)
+	for _, offer := range offers {
+		q, pos, spec := 0.0, len(ranges), 0
+		for i, r := range ranges {
+			if s := specificity(r.mediaType, offer); s > spec {
+				q, pos, spec = r.q, i, s
+			}
+		}
+
+		// com q igual vale a ordem do header e depois a ordem das ofertas
+		if q > bestQ || (q == bestQ && q > 0 && pos < bestPos) {
+			best, bestQ, bestPos = offer, q, pos
+		}
+	}
+
+	return best
+}
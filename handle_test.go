@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type createUserRequest struct {
+	OrgID  string `path:"org"`
+	Notify bool   `query:"notify"`
+	Name   string `json:"name"`
+	Age    int    `json:"age"`
+}
+
+func (r createUserRequest) Validate() error {
+	if r.Name == "" {
+		return errors.New("name é obrigatório")
+	}
+	return nil
+}
+
+type createUserResponse struct {
+	ID     string `json:"id" xml:"id"`
+	Name   string `json:"name" xml:"name"`
+	Notify bool   `json:"notify" xml:"notify"`
+}
+
+var handleCreateUser = Handle(func(ctx context.Context, req createUserRequest) (createUserResponse, error) {
+	return createUserResponse{ID: req.OrgID + "-1", Name: req.Name, Notify: req.Notify}, nil
+})
+
+var createUserRoute = RouteInfo{Path: "/orgs/{org}/users", Method: MethodPost, Handler: handleCreateUser}
+
+func TestHandle(t *testing.T) {
+	t.Run("Testando bind de path, query e body", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/orgs/tupa/users?notify=true", strings.NewReader(`{"name":"Victor","age":24}`))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := serveRoute(createUserRoute, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("esperava status 200, recebeu %d: %s", w.Code, w.Body.String())
+		}
+		if want := `{"id":"tupa-1","name":"Victor","notify":true}`; strings.TrimSpace(w.Body.String()) != want {
+			t.Errorf("esperava %s, recebeu %s", want, w.Body.String())
+		}
+	})
+
+	t.Run("Testando default só nos campos que nenhuma fonte preencheu", func(t *testing.T) {
+		type factRequest struct {
+			MaxLength int    `json:"max_length" query:"max_length" default:"140"`
+			Lang      string `json:"lang" query:"lang" default:"pt"`
+			Fact      string `json:"fact"`
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/fatos?fact=query", strings.NewReader(`{"max_length":50,"fact":"body"}`))
+		req.Header.Set("Content-Type", "application/json")
+		tc := (&TupaContext{}).NewTupaContext(httptest.NewRecorder(), req)
+
+		var got factRequest
+		if err := bindRequest(tc, &got); err != nil {
+			t.Fatalf("erro no bind: %v", err)
+		}
+		if want := (factRequest{MaxLength: 50, Lang: "pt", Fact: "body"}); got != want {
+			t.Errorf("esperava %+v, recebeu %+v", want, got)
+		}
+	})
+
+	t.Run("Testando validação", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/orgs/tupa/users", strings.NewReader(`{"age":24}`))
+
+		if w := serveRoute(createUserRoute, req); w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("esperava status 422, recebeu %d", w.Code)
+		}
+	})
+
+	t.Run("Testando body inválido", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/orgs/tupa/users", strings.NewReader(`{"name":`))
+
+		if w := serveRoute(createUserRoute, req); w.Code != http.StatusBadRequest {
+			t.Errorf("esperava status 400, recebeu %d", w.Code)
+		}
+	})
+
+	t.Run("Testando negociação de XML", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/orgs/tupa/users", strings.NewReader(`{"name":"Victor"}`))
+		req.Header.Set("Accept", "application/json;q=0.5, application/xml")
+
+		w := serveRoute(createUserRoute, req)
+
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/xml") {
+			t.Errorf("esperava XML, recebeu %s", ct)
+		}
+		if !strings.Contains(w.Body.String(), "<name>Victor</name>") {
+			t.Errorf("body XML inesperado: %s", w.Body.String())
+		}
+	})
+
+	t.Run("Testando Accept sem formato aceitável", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/orgs/tupa/users", strings.NewReader(`{"name":"Victor"}`))
+		req.Header.Set("Accept", "application/json;q=0")
+
+		if w := serveRoute(createUserRoute, req); w.Code != http.StatusNotAcceptable {
+			t.Errorf("esperava status 406, recebeu %d", w.Code)
+		}
+	})
+
+	t.Run("Testando tipos registrados na rota", func(t *testing.T) {
+		server := NewAPIServer(":8080")
+		server.RegisterRoutes([]RouteInfo{
+			createUserRoute,
+			{Path: "/", Method: MethodGet, Handler: APIFunc(WelcomeHandler)},
+		})
+
+		routes := server.Routes()
+		if routes[0].RequestType != reflect.TypeOf(createUserRequest{}) || routes[0].ResponseType != reflect.TypeOf(createUserResponse{}) {
+			t.Errorf("tipos inesperados: %v %v", routes[0].RequestType, routes[0].ResponseType)
+		}
+		if routes[1].RequestType != nil {
+			t.Errorf("handler comum não deveria ter tipos, recebeu %v", routes[1].RequestType)
+		}
+	})
+}
+
+func TestNegotiateContentType(t *testing.T) {
+	offers := []string{"application/json", "application/xml", "text/plain"}
+
+	tests := map[string]string{
+		"":                                 "application/json",
+		"*/*":                              "application/json",
+		"text/*":                           "text/plain",
+		"application/xml;q=0.9, */*;q=0.1": "application/xml",
+		"image/png":                        "",
+		"application/json;q=0":             "",
+		"application/json;q=0, */*;q=0.5":  "application/xml",
+	}
+
+	for accept, want := range tests {
+		if got := negotiateContentType(accept, offers...); got != want {
+			t.Errorf("Accept %q: esperava %s, recebeu %s", accept, want, got)
+		}
+	}
+}
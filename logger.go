@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+)
+
+// LOGGER
+
+// levelHandler aplica o nível configurado no servidor sobre qualquer slog.Handler
+type levelHandler struct {
+	level *slog.LevelVar
+	slog.Handler
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level() && h.Handler.Enabled(ctx, level)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{level: h.level, Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{level: h.level, Handler: h.Handler.WithGroup(name)}
+}
+
+// SetLogHandler troca o handler usado pelos logs do servidor e das requests,
+// por exemplo slog.NewJSONHandler para produção
+func (a *APIServer) SetLogHandler(handler slog.Handler) {
+	a.logger = slog.New(&levelHandler{level: &a.logLevel, Handler: handler})
+}
+
+// SetLogLevel define o nível mínimo dos logs, valendo também para handlers customizados
+func (a *APIServer) SetLogLevel(level slog.Level) {
+	a.logLevel.Set(level)
+}
+
+// Logger retorna o logger base do servidor
+func (a *APIServer) Logger() *slog.Logger {
+	if a == nil || a.logger == nil {
+		return slog.Default()
+	}
+	return a.logger
+}
+
+// Logger retorna o logger da request, com request ID, método, rota, IP do cliente e,
+// depois da autenticação, o ID do usuário. Atributos adicionados com AddLogAttrs
+// aparecem em todas as linhas seguintes da mesma request
+func (tc *TupaContext) Logger() *slog.Logger {
+	if tc.logger != nil {
+		return tc.logger
+	}
+
+	attrs := make([]any, 0, 10)
+	if tc.requestID != "" {
+		attrs = append(attrs, slog.String("request_id", tc.requestID))
+	}
+
+	if tc.request != nil {
+		attrs = append(attrs, slog.String("method", tc.request.Method))
+		if tc.route != nil {
+			attrs = append(attrs, slog.String("route", tc.route.Path))
+		} else {
+			attrs = append(attrs, slog.String("path", tc.request.URL.Path))
+		}
+		attrs = append(attrs, slog.String("client_ip", tc.RealIP()))
+	}
+
+	if tc.userID != "" {
+		attrs = append(attrs, slog.String("user_id", tc.userID))
+	}
+
+	tc.logger = tc.server.Logger().With(attrs...)
+	return tc.logger
+}
+
+// AddLogAttrs adiciona atributos ao logger da request, no formato de slog.Logger.With
+func (tc *TupaContext) AddLogAttrs(args ...any) {
+	tc.logger = tc.Logger().With(args...)
+}
+
+// SetUserID registra o usuário autenticado da request, inclusive nos logs
+func (tc *TupaContext) SetUserID(id string) {
+	tc.userID = id
+
+	// se o logger ainda não foi criado ele já vai nascer com o user_id
+	if tc.logger != nil {
+		tc.AddLogAttrs(slog.String("user_id", id))
+	}
+}
+
+func (tc *TupaContext) UserID() string {
+	return tc.userID
+}
+
+// RequestID retorna o ID de correlação da request, se houver
+func (tc *TupaContext) RequestID() string {
+	return tc.requestID
+}
+
+// Route retorna a rota que atendeu a request
+func (tc *TupaContext) Route() *RouteInfo {
+	return tc.route
+}
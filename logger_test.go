@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestLogger(t *testing.T) {
+	var buf bytes.Buffer
+
+	server := NewAPIServer(":8080")
+	server.SetLogHandler(slog.NewJSONHandler(&buf, nil))
+	server.SetLogLevel(slog.LevelWarn)
+
+	addAttrs := func(next APIFunc) APIFunc {
+		return func(tc *TupaContext) error {
+			tc.AddLogAttrs("tenant", "tupa")
+			return next(tc)
+		}
+	}
+
+	handler := server.MakeHTTPHandlerFuncHelper(RouteInfo{
+		Path:        "/users/{id}",
+		Method:      MethodGet,
+		Middlewares: []Middleware{MiddlewareFunc(addAttrs)},
+		Handler: APIFunc(func(tc *TupaContext) error {
+			tc.SetUserID("2602")
+			tc.Logger().Info("não deveria aparecer")
+			tc.Logger().Warn("buscando usuário")
+			return nil
+		}),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	req.RemoteAddr = "198.51.100.7:4000"
+	handler(httptest.NewRecorder(), req)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("esperava 1 linha de log com nível Warn, recebeu %d: %s", len(lines), buf.String())
+	}
+
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]any{
+		"msg":       "buscando usuário",
+		"method":    "GET",
+		"route":     "/users/{id}",
+		"client_ip": "198.51.100.7",
+		"tenant":    "tupa",
+		"user_id":   "2602",
+	}
+	for k, v := range want {
+		if entry[k] != v {
+			t.Errorf("esperava %s=%v no log, recebeu %v", k, v, entry[k])
+		}
+	}
+}
@@ -9,8 +9,10 @@ import (
 	"html/template"
 	"io"
 	"log"
+	"log/slog"
 	"math/big"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -18,6 +20,8 @@ import (
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -52,23 +56,64 @@ type (
 	TupaContext struct {
 		request  *http.Request
 		response http.ResponseWriter
+		// context atual quando não há request; com request vale o context dela
 		context.Context
+		// contexts que respondiam antes de cada WithContext com um context derivado do tc,
+		// veja layer
+		valueLayers []context.Context
+		depth       atomic.Int32
+		// funções que rodam ao fim da request, depois do dispatcher escrever o erro
+		releaseHooks []func()
+		server       *APIServer
+		route        *RouteInfo
+		requestID    string
+		userID       string
+		logger       *slog.Logger
+		body         []byte
+		bodyRead     bool
+		bodyLimit    int64
+		aborted      bool
+		storeMu      sync.RWMutex
+		store        map[any]any
+		writer       TupaResponseWriter
 	}
 )
 
 type APIFunc func(*TupaContext) error
 
+// Handler atende uma request. APIFunc é a forma comum; Handle devolve um *TypedHandler,
+// que além de atender a request carrega os tipos de entrada e saída
+type Handler interface {
+	ServeTupa(tc *TupaContext) error
+}
+
+func (f APIFunc) ServeTupa(tc *TupaContext) error {
+	return f(tc)
+}
+
 type APIServer struct {
 	listenAddr        string
 	server            *http.Server
 	globalMiddlewares MiddlewareChain
 	router            *mux.Router
+	cookieKeyring     *CookieKeyring
+	trustedProxies    []*net.IPNet
+	handlers          []*routeHandler
+	logger            *slog.Logger
+	logLevel          slog.LevelVar
+	maxBodySize       int64
+	recover           Middleware
+	// started impede remontar as chains depois que o servidor começou a atender requests
+	started atomic.Bool
 }
 
 type HTTPMethod string
 
 type APIError struct {
-	Error string
+	Error     string
+	Code      string `json:",omitempty"`
+	Details   any    `json:",omitempty"`
+	RequestID string `json:",omitempty"`
 }
 
 const (
@@ -91,17 +136,29 @@ var AllowedMethods = map[HTTPMethod]bool{
 type RouteInfo struct {
 	Path        string
 	Method      HTTPMethod
-	Handler     APIFunc
-	Middlewares []MiddlewareFunc
+	Handler     Handler
+	Middlewares []Middleware
+	// Tipos de entrada e saída, preenchidos no registro quando o handler foi criado por Handle
+	RequestType  reflect.Type
+	ResponseType reflect.Type
+	// ExcludeGlobal faz a rota ignorar os middlewares globais, como em health checks
+	ExcludeGlobal bool
+	// ExcludeMiddlewares remove da chain da rota os middlewares com esses nomes
+	ExcludeMiddlewares []string
+	// Meta guarda informações livres da rota, lidas por predicates de When e por middlewares
+	Meta map[string]any
+	// Chain são os nomes dos middlewares da rota na ordem de execução, preenchido no registro
+	Chain []string
 }
 
 func (a *APIServer) New() {
 	if a.router.GetRoute("/") == nil {
 		a.RegisterRoutes([]RouteInfo{
 			{
-				Path:    "/",
-				Method:  MethodGet,
-				Handler: WelcomeHandler,
+				Path:        "/",
+				Method:      MethodGet,
+				Handler:     APIFunc(WelcomeHandler),
+				Middlewares: []Middleware{welcomeCache.Middleware()},
 			},
 		})
 	}
@@ -138,6 +195,7 @@ func (a *APIServer) New() {
 		Addr:    a.listenAddr,
 		Handler: routerHandler,
 	}
+	a.started.Store(true)
 
 	fmt.Println(FmtBlue("Servidor iniciado na porta: " + a.listenAddr))
 
@@ -166,11 +224,15 @@ func NewAPIServer(listenAddr string) *APIServer {
 	router := mux.NewRouter()
 	// router.Use(accessControlMiddleware)
 
-	return &APIServer{
+	server := &APIServer{
 		listenAddr:        listenAddr,
 		router:            router,
 		globalMiddlewares: MiddlewareChain{},
+		recover:           Recover(RecoverConfig{}),
 	}
+	server.SetLogHandler(slog.NewTextHandler(os.Stderr, nil))
+
+	return server
 }
 
 // func accessControlMiddleware(next http.Handler) http.Handler {
@@ -207,6 +269,8 @@ func NewAPIServer(listenAddr string) *APIServer {
 // 	}
 // }
 
+var welcomeCache = NewResponseCache(ResponseCacheConfig{TTL: time.Hour})
+
 func WelcomeHandler(tc *TupaContext) error {
 	WriteJSONHelper(tc.response, http.StatusOK, "Seja bem vindo ao Tupã framework!")
 	return nil
@@ -218,17 +282,22 @@ func (a *APIServer) RegisterRoutes(routeInfos []RouteInfo) {
 			log.Fatalf(fmt.Sprintf(FmtRed("Método HTTP não permitido: "), "%s\nVeja como criar um novo método na documentação", routeInfo.Method))
 		}
 
-		var allMiddlewares MiddlewareChain
-		middlewaresGlobais := a.GetGlobalMiddlewares()
-
-		allMiddlewares = append(allMiddlewares, middlewaresGlobais...)
-
-		handler := a.MakeHTTPHandlerFuncHelper(routeInfo, allMiddlewares, a.globalMiddlewares)
+		handler := a.MakeHTTPHandlerFuncHelper(routeInfo)
 
 		a.router.HandleFunc(routeInfo.Path, handler).Methods(string(routeInfo.Method))
 	}
 }
 
+// Routes retorna as rotas registradas no servidor, com os tipos dos handlers tipados
+// e a chain de middlewares resolvida
+func (a *APIServer) Routes() []RouteInfo {
+	routes := make([]RouteInfo, len(a.handlers))
+	for i, rh := range a.handlers {
+		routes[i] = rh.info
+	}
+	return routes
+}
+
 func WriteJSONHelper(w http.ResponseWriter, status int, v any) error {
 	if w == nil {
 		return errors.New("Response writer passado está nulo")
@@ -240,39 +309,124 @@ func WriteJSONHelper(w http.ResponseWriter, status int, v any) error {
 	return json.NewEncoder(w).Encode(v)
 }
 
-func (a *APIServer) MakeHTTPHandlerFuncHelper(routeInfo RouteInfo, middlewares MiddlewareChain, globalMiddlewares MiddlewareChain) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		ctx := &TupaContext{
-			request:  r,
-			response: w,
+// routeHandler guarda a rota com o handler já envolvido pelos middlewares, para não
+// compor a chain a cada request
+type routeHandler struct {
+	info    RouteInfo
+	handler APIFunc
+}
+
+// rebuildHandlers remonta as chains de todas as rotas. Os handlers são lidos sem lock pelas
+// requests, então quem chama precisa garantir que o servidor ainda não começou a atender
+func (a *APIServer) rebuildHandlers() {
+	for _, rh := range a.handlers {
+		if err := rh.build(a); err != nil {
+			panic(err)
 		}
+	}
+}
+
+// build compõe o handler da rota com os middlewares globais por fora e os da rota
+// (grupo e depois rota, na ordem de RouteInfo.Middlewares) por dentro, aplicando
+// as exclusões e as restrições de ordem dos middlewares nomeados. O recover do servidor
+// fica fora de tudo, para pegar panics de qualquer middleware
+func (rh *routeHandler) build(a *APIServer) error {
+	globalMiddlewares := a.globalMiddlewares
+	if rh.info.ExcludeGlobal {
+		globalMiddlewares = nil
+	}
+
+	chain := make(MiddlewareChain, 0, len(globalMiddlewares)+len(rh.info.Middlewares))
+	chain = append(chain, globalMiddlewares...)
+	chain = append(chain, rh.info.Middlewares...)
+	for _, name := range rh.info.ExcludeMiddlewares {
+		chain.Remove(name)
+	}
+
+	resolved, err := chain.resolve()
+	if err != nil {
+		return fmt.Errorf("rota %s %s: %w", rh.info.Method, rh.info.Path, err)
+	}
+
+	if a.recover != nil {
+		resolved = append(MiddlewareChain{a.recover}, resolved...)
+	}
 
-		// Combina middlewares globais com os especificos de rota
-		allMiddlewares := MiddlewareChain{}
-		allMiddlewares = append(allMiddlewares, a.GetGlobalMiddlewares()...)
-		allMiddlewares = append(allMiddlewares, routeInfo.Middlewares...)
+	rh.info.Chain = resolved.Names()
+	rh.handler = resolved.Then(rh.endpoint)
+	return nil
+}
 
-		doneCh := a.executeMiddlewaresAsync(ctx, allMiddlewares)
-		errorsSlice := <-doneCh // espera até que algum valor seja recebido. Continua no primeiro erro recebido ( se houver ) ou se não houver nenhum erro
+func (rh *routeHandler) endpoint(tc *TupaContext) error {
+	// um middleware que chamou Abort encerra a request mesmo se chamar next
+	if tc.aborted {
+		return nil
+	}
 
-		if len(errorsSlice) > 0 {
-			WriteJSONHelper(w, http.StatusInternalServerError, APIError{Error: errorsSlice[0].Error()})
-			return
+	if tc.request.Method != string(rh.info.Method) {
+		return NewHTTPError(http.StatusMethodNotAllowed, "Método HTTP não permitido")
+	}
+
+	return rh.info.Handler.ServeTupa(tc)
+}
+
+// MakeHTTPHandlerFuncHelper monta o http.HandlerFunc da rota. Os TupaContext vêm de um
+// sync.Pool e a chain é composta aqui, então o caminho da request não cria goroutines
+// nem slices de middlewares
+func (a *APIServer) MakeHTTPHandlerFuncHelper(routeInfo RouteInfo) http.HandlerFunc {
+	if types, ok := TypesOf(routeInfo.Handler); ok {
+		routeInfo.RequestType = types.Request
+		routeInfo.ResponseType = types.Response
+	}
+
+	rh := &routeHandler{info: routeInfo}
+	// restrições de ordem impossíveis são erro de programação, então falhamos no registro
+	if err := rh.build(a); err != nil {
+		panic(err)
+	}
+	a.handlers = append(a.handlers, rh)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := acquireTupaContext(a, &rh.info, w, r)
+		defer releaseTupaContext(ctx)
+
+		if logger := a.Logger(); logger.Enabled(r.Context(), slog.LevelDebug) {
+			ctx.Logger().Debug("request recebida", "path", r.URL.Path)
+		}
+
+		if err := rh.handler(ctx); err != nil {
+			ctx.WriteError(err)
 		}
+	}
+}
 
-		log.Println(r.Method, r.URL.Path)
+// WriteError escreve o erro como APIError, com o status e os headers de um HTTPError se houver.
+// Se a resposta já tiver sido enviada um segundo body corromperia a resposta, então o erro só é logado
+func (tc *TupaContext) WriteError(err error) {
+	status := errorStatus(err)
 
-		if r.Method == string(routeInfo.Method) {
-			if err := routeInfo.Handler(ctx); err != nil {
-				if err := WriteJSONHelper(w, http.StatusInternalServerError, APIError{Error: err.Error()}); err != nil {
-					fmt.Println("Erro ao escrever resposta JSON:", err)
-				}
-			}
+	if tc.Writer().Committed() {
+		tc.Logger().Error("erro após resposta enviada", "status", tc.Writer().Status(), "error", err)
+		return
+	}
+
+	if status >= http.StatusInternalServerError {
+		tc.Logger().Error("erro na request", "status", status, "error", err)
+	} else {
+		tc.Logger().Debug("erro na request", "status", status, "error", err)
+	}
 
-		} else {
-			WriteJSONHelper(w, http.StatusMethodNotAllowed, APIError{Error: "Método HTTP não permitido"})
+	body, header := toAPIError(err, status)
+	body.RequestID = tc.requestID
+	for key, values := range header {
+		for _, value := range values {
+			tc.response.Header().Add(key, value)
 		}
 	}
+
+	if err := WriteJSONHelper(tc.response, status, body); err != nil {
+		tc.Logger().Error("erro ao escrever resposta JSON", "error", err)
+	}
 }
 
 func (tc *TupaContext) Request() *http.Request {
@@ -283,6 +437,17 @@ func (tc *TupaContext) Response() *http.ResponseWriter {
 	return &tc.response
 }
 
+// Writer retorna o TupaResponseWriter da request, com status, tamanho e estado da resposta
+func (tc *TupaContext) Writer() *TupaResponseWriter {
+	if rw, ok := tc.response.(*TupaResponseWriter); ok {
+		return rw
+	}
+
+	rw := NewTupaResponseWriter(tc.response)
+	tc.response = rw
+	return rw
+}
+
 func (tc *TupaContext) SendString(s string) error {
 	_, err := tc.response.Write([]byte(s))
 	return err
@@ -301,10 +466,9 @@ func (tc *TupaContext) QueryParams() map[string][]string {
 }
 
 func (tc *TupaContext) NewTupaContext(w http.ResponseWriter, r *http.Request) *TupaContext {
-	return &TupaContext{
-		request:  r,
-		response: w,
-	}
+	ctx := &TupaContext{}
+	ctx.reset(nil, nil, w, r)
+	return ctx
 }
 
 // TESTES HANDLERS
@@ -362,12 +526,19 @@ func HandleEndpointQueryParam(tc *TupaContext) error {
 // 	return nil
 // }
 
+type QueryParamsExample struct {
+	Name  string `query:"name" default:"visitante"`
+	Idade int    `query:"idade"`
+}
+
 func HandleEndpointQueryParams(tc *TupaContext) error {
-	param := tc.QueryParams()
-	fmt.Println(param)
-	fmt.Println(param["idade"])
-	fmt.Println(param["name"])
-	tc.SendString("Hello " + param["name"][0])
+	var params QueryParamsExample
+	if err := tc.BindQuery(&params); err != nil {
+		return err
+	}
+
+	fmt.Println(params)
+	tc.SendString("Hello " + params.Name)
 	return nil
 }
 
@@ -385,14 +556,18 @@ func PrintRoutes(router *mux.Router) {
 
 // MIDDLEWARES EXEMPLOS
 
+var (
+	ctxTextKey      = NewKey[string]("ctxText")
+	smpErrorMiddKey = NewKey[string]("smpErrorMidd")
+)
+
 func LoggingMiddleware(next APIFunc) APIFunc {
 	return func(tc *TupaContext) error {
-		log.Println("Antes de chamar o handler")
-		defer log.Println("Depois de chamar o handler")
+		tc.AddLogAttrs("ctx_text", "2602")
+		tc.Logger().Info("Antes de chamar o handler")
+		defer tc.Logger().Info("Depois de chamar o handler")
 
-		tupaContextWithValue := context.WithValue(tc.request.Context(), "ctxText", "2602")
-
-		tc.request = tc.request.WithContext(tupaContextWithValue)
+		ctxTextKey.Set(tc, "2602")
 		// chamando o handler original
 		return next(tc)
 	}
@@ -428,8 +603,7 @@ func LoggingMiddlewareWithErrorrr(next APIFunc) APIFunc {
 		// escrevendo o erro no body da req
 		// tc.response.Write([]byte(`{"Error":"` + "houve um erro" + `"}`))
 
-		ctx := context.WithValue(tc.request.Context(), "smpErrorMidd", "sampleErrorMiddleware")
-		tc.request = tc.request.WithContext(ctx)
+		smpErrorMiddKey.Set(tc, "sampleErrorMiddleware")
 
 		return errors.New("erro no middleware LoggingMiddlewareWithError")
 	}
@@ -493,6 +667,18 @@ func MiddlewareGLOBAL(next APIFunc) APIFunc {
 // user
 func main() {
 	server := NewAPIServer(":6969")
+	// nginx roda na mesma máquina
+	if err := server.SetTrustedProxies("127.0.0.1", "::1"); err != nil {
+		log.Fatal(err)
+	}
+
+	// arquivos estáticos não precisam de sessão
+	server.UseGlobalMiddleware(RequestIDMiddleware(RequestIDConfig{}))
+	server.UseGlobalMiddleware(AccessLog(AccessLogConfig{Format: AccessLogDev}))
+	server.UseGlobalMiddleware(Compress(CompressConfig{}))
+	// só as rotas com RateLimitMetaKey são limitadas
+	server.UseGlobalMiddleware(RateLimiter(RateLimitConfig{}))
+	server.UseGlobalMiddleware(Skip(SkipRule{Prefixes: []string{"/static/", "/download/"}}, SessionMiddleware(SessionConfig{})))
 
 	ExampleRouteManagerTupa()
 	// AddRoutes(nil, ContrTestAuthCors)
@@ -554,7 +740,7 @@ func UploadFile(tc *TupaContext, filePrefix, destFolder, formFileKey string) (mu
 
 	// copia o arquivo do upload para o arquivo criado no SO
 	if _, err := io.Copy(destFile, file); err != nil {
-		return multipart.FileHeader{}, WriteJSONHelper(*tc.Response(), http.StatusInternalServerError, err.Error())
+		return multipart.FileHeader{}, err
 	}
 
 	fmt.Fprint(*tc.Response(), "Arquivo salvo com sucesso\n")
@@ -617,7 +803,7 @@ func ExampleRouteManagerTupa() {
 	// tupa.AddRoutes(tupa.MiddlewareChain{MiddlewareContrBTupa}, ContrBRoutesTupa)
 
 	// tupa.AddRoutes(tupa.MiddlewareChain{MiddlewareContrCTupa}, ContrCRoutesTupa)
-	AddRoutes(nil, ContrUploadImage)
+	AddRoutes(nil, ContrUploadImage, ContrCatRoutes, ContrFileRoutes)
 }
 
 // func handleSendStringTupa(tc *tupa.TupaContext) error {
@@ -650,27 +836,33 @@ const IndexPage = `
 // 	return tc.SendString("Hello world oauth")
 // }
 
+var uploadBulkhead = NewBulkhead(BulkheadConfig{MaxConcurrent: 4, MaxQueue: 16, QueueTimeout: 5 * time.Second})
+
 func ContrUploadImage() []RouteInfo {
 	return []RouteInfo{
 		{
 			Path:    "/form",
 			Method:  "GET",
-			Handler: GetFileHandler,
+			Handler: APIFunc(GetFileHandler),
 		},
 		{
 			Path:    "/upload",
 			Method:  "POST",
-			Handler: UploadFileHandler,
+			Handler: APIFunc(UploadFileHandler),
+			// uploads são lentos e usam disco, então limitamos quantos rodam juntos
+			Middlewares: []Middleware{uploadBulkhead.Middleware()},
+			Meta:        map[string]any{RateLimitMetaKey: RateLimit{Limit: 10, Window: time.Minute, Burst: 3}},
 		},
 		{
 			Path:    "/api/v1/auth/google",
 			Method:  "GET",
-			Handler: AuthGoogleLogin,
+			Handler: APIFunc(AuthGoogleLogin),
+			Meta:    map[string]any{RateLimitMetaKey: RateLimit{Limit: 20, Window: time.Minute, Algorithm: SlidingWindow}},
 		},
 		{
 			Path:    "/api/v1/auth/google/callback",
 			Method:  "GET",
-			Handler: AuthGoogleCallbackFunc,
+			Handler: APIFunc(AuthGoogleCallbackFunc),
 		},
 	}
 }
@@ -680,8 +872,8 @@ func AuthGoogleLogin(tc *TupaContext) error {
 	clientID := "328882923422-gg4m2s4druhop7fif2tro6dv7k97onk5.apps.googleusercontent.com"
 	clientSecret := "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"
 
-	apiGoogleCallbackUrl := fmt.Sprintf("%s/%s/auth/google/callback", "http://localhost:6969", "api/v1")
-	UseGoogleOauth(clientID, clientSecret, apiGoogleCallbackUrl, "http://localhost:6969", []string{"https://www.googleapis.com/auth/userinfo.email"})
+	apiGoogleCallbackUrl := tc.AbsoluteURL("/api/v1/auth/google/callback")
+	UseGoogleOauth(clientID, clientSecret, apiGoogleCallbackUrl, tc.AbsoluteURL("/"), []string{"https://www.googleapis.com/auth/userinfo.email"})
 
 	if err := AuthGoogleHandler(tc); err != nil {
 		return err
@@ -714,7 +906,7 @@ func AuthGoogleCallbackFunc(tc *TupaContext) error {
 	// 	return nil, nil
 	// }
 
-	token, err := GoogleOauthConfig.Exchange(context.Background(), code)
+	token, err := GoogleOauthConfig.Exchange(tc, code)
 	if err != nil {
 		fmt.Printf("Exchange do código falhou '%s'\n", err)
 		return err
@@ -748,6 +940,24 @@ func AuthGoogleCallbackFunc(tc *TupaContext) error {
 		UserInfo: userInfo,
 		Token:    token,
 	})
+
+	// novo ID de sessão no login para evitar session fixation
+	session := tc.Session()
+	if err := session.Regenerate(); err != nil {
+		return err
+	}
+
+	if err := session.Set("user", userInfo); err != nil {
+		return err
+	}
+	if err := session.Set(SessionUserIDKey, userInfo.ID); err != nil {
+		return err
+	}
+	session.AddFlash("Login realizado com sucesso")
+
+	tc.SetUserID(userInfo.ID)
+	tc.Logger().Info("usuário autenticado com o Google")
+
 	return nil
 
 	// var response map[string]string
@@ -2,6 +2,9 @@ package main
 
 import (
 	"context"
+	"errors"
+	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"sync"
@@ -32,6 +35,94 @@ func BenchmarkDirectAccessSendString(b *testing.B) {
 	b.ReportMetric(opsPerSec, "ops/sec")
 }
 
+// benchWriter é um ResponseWriter reaproveitável, para que o benchmark meça só as alocações do framework
+type benchWriter struct {
+	header http.Header
+}
+
+func (w *benchWriter) Header() http.Header         { return w.header }
+func (w *benchWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *benchWriter) WriteHeader(int)             {}
+
+type benchMessage struct {
+	Message string `json:"message"`
+}
+
+func newBenchJSONRoute(middlewares ...Middleware) http.HandlerFunc {
+	server := NewAPIServer(":6969")
+	server.SetLogHandler(slog.NewTextHandler(io.Discard, nil))
+	server.UseGlobalMiddleware(middlewares...)
+
+	return server.MakeHTTPHandlerFuncHelper(RouteInfo{
+		Path:   "/bench",
+		Method: MethodGet,
+		Handler: APIFunc(func(tc *TupaContext) error {
+			return WriteJSONHelper(tc.Writer(), http.StatusOK, benchMessage{Message: "Hello, World!"})
+		}),
+	})
+}
+
+func runBenchJSONRoute(b *testing.B, handler http.HandlerFunc) {
+	req := httptest.NewRequest(http.MethodGet, "/bench", nil)
+	w := &benchWriter{header: make(http.Header)}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		handler(w, req)
+	}
+}
+
+func BenchmarkJSONRoute(b *testing.B) {
+	runBenchJSONRoute(b, newBenchJSONRoute())
+}
+
+func BenchmarkJSONRouteWithMiddlewares(b *testing.B) {
+	var passThrough MiddlewareFunc = func(next APIFunc) APIFunc {
+		return func(tc *TupaContext) error {
+			return next(tc)
+		}
+	}
+
+	runBenchJSONRoute(b, newBenchJSONRoute(passThrough, passThrough, passThrough))
+}
+
+func BenchmarkJSONRouteParallel(b *testing.B) {
+	handler := newBenchJSONRoute()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		req := httptest.NewRequest(http.MethodGet, "/bench", nil)
+		w := &benchWriter{header: make(http.Header)}
+		for pb.Next() {
+			handler(w, req)
+		}
+	})
+}
+
+// serveRoute registra route em um servidor novo, com os middlewares globais dados, e
+// passa req pelo router como o servidor faria
+func serveRoute(route RouteInfo, req *http.Request, middlewares ...Middleware) *httptest.ResponseRecorder {
+	server := NewAPIServer(":6969")
+	server.UseGlobalMiddleware(middlewares...)
+	server.RegisterRoutes([]RouteInfo{route})
+
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	return w
+}
+
+// newTestContext cria um TupaContext fora do pool, ligado a um servidor novo, para testes
+// que chamam os métodos do tc direto
+func newTestContext(w http.ResponseWriter, req *http.Request) *TupaContext {
+	tc := (&TupaContext{}).NewTupaContext(w, req)
+	tc.server = NewAPIServer(":6969")
+	return tc
+}
+
 func TestConcurrentSendStringCtxExample(t *testing.T) {
 	var wg sync.WaitGroup
 
@@ -194,7 +285,148 @@ func TestNewTupaContext(t *testing.T) {
 		t.Errorf("esperava request %v, mas recebeu %v", r, result.request)
 	}
 
-	if result.response != w {
-		t.Errorf("esperava response %v, mas recebeu %v", w, result.response)
+	if result.Writer().Unwrap() != w {
+		t.Errorf("esperava response %v, mas recebeu %v", w, result.Writer().Unwrap())
 	}
 }
+
+func TestTupaContextLifecycle(t *testing.T) {
+	t.Run("Testando context populado pelo dispatcher", func(t *testing.T) {
+		server := NewAPIServer(":8080")
+
+		var done <-chan struct{}
+		handler := server.MakeHTTPHandlerFuncHelper(RouteInfo{
+			Path:   "/",
+			Method: MethodGet,
+			Handler: APIFunc(func(tc *TupaContext) error {
+				done = tc.Done()
+				if _, ok := tc.Deadline(); ok {
+					t.Errorf("não esperava deadline")
+				}
+				return nil
+			}),
+		})
+
+		reqCtx, cancel := context.WithCancel(context.Background())
+		req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(reqCtx)
+		handler(httptest.NewRecorder(), req)
+
+		if done == nil {
+			t.Fatal("esperava canal Done do context da request")
+		}
+
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Errorf("cancelamento da request não chegou no TupaContext")
+		}
+	})
+
+	t.Run("Testando WithContext mantém request e context em sincronia", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		tc := (&TupaContext{}).NewTupaContext(httptest.NewRecorder(), req)
+
+		ctx, cancel := context.WithTimeout(tc, time.Minute)
+		defer cancel()
+		tc.WithContext(ctx)
+
+		if _, ok := tc.Deadline(); !ok {
+			t.Errorf("esperava deadline no TupaContext")
+		}
+		if _, ok := tc.Request().Context().Deadline(); !ok {
+			t.Errorf("esperava deadline no context da request")
+		}
+	})
+
+	t.Run("Testando context derivado do próprio TupaContext", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(context.WithValue(context.Background(), "ctxText", "2602"))
+		tc := (&TupaContext{}).NewTupaContext(httptest.NewRecorder(), req)
+
+		ctx, cancel := context.WithTimeout(tc, time.Minute)
+		tc.WithContext(ctx)
+		tc.WithContext(context.WithValue(tc, "outro", "valor"))
+
+		if v := tc.Value("ctxText"); v != "2602" {
+			t.Errorf("esperava '2602', recebeu %v", v)
+		}
+		if _, ok := tc.Deadline(); !ok {
+			t.Errorf("esperava deadline no TupaContext")
+		}
+		if v := tc.Request().Context().Value("outro"); v != "valor" {
+			t.Errorf("esperava 'valor' no context da request, recebeu %v", v)
+		}
+		if v := tc.Value("outro"); v != "valor" {
+			t.Errorf("esperava 'valor' no TupaContext, recebeu %v", v)
+		}
+		if v := tc.Value("inexistente"); v != nil {
+			t.Errorf("não esperava valor, recebeu %v", v)
+		}
+
+		tc.WithContext(context.WithValue(tc, "ctxText", "novo"))
+		if v := tc.Value("ctxText"); v != "novo" {
+			t.Errorf("o valor mais novo deveria valer, recebeu %v", v)
+		}
+
+		cancel()
+		select {
+		case <-tc.Done():
+		case <-time.After(time.Second):
+			t.Errorf("cancelamento do context derivado não chegou no TupaContext")
+		}
+		if !errors.Is(tc.Err(), context.Canceled) {
+			t.Errorf("esperava context.Canceled, recebeu %v", tc.Err())
+		}
+	})
+
+	t.Run("Testando Detach depois do tc voltar para o pool", func(t *testing.T) {
+		reqCtx, cancel := context.WithCancel(context.WithValue(context.Background(), "ctxText", "2602"))
+		tc := acquireTupaContext(nil, nil, httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil).WithContext(reqCtx))
+		tc.SetRequestID("req-1")
+		tc.WithContext(context.WithValue(tc, "outro", "valor"))
+
+		detached := tc.Detach()
+		cancel()
+		releaseTupaContext(tc)
+
+		// o mesmo TupaContext atendendo outra request
+		tc.reset(nil, nil, httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		tc.SetRequestID("req-2")
+		defer releaseTupaContext(tc)
+
+		if id := RequestIDFromContext(detached); id != "req-1" {
+			t.Errorf("esperava o ID da primeira request, recebeu %q", id)
+		}
+		if v := detached.Value("ctxText"); v != "2602" {
+			t.Errorf("esperava os valores da request, recebeu %v", v)
+		}
+		if v := detached.Value("outro"); v != nil {
+			t.Errorf("valores de contexts derivados do tc não deveriam entrar, recebeu %v", v)
+		}
+		if detached.Err() != nil {
+			t.Errorf("o context destacado não deveria ser cancelado com a request: %v", detached.Err())
+		}
+	})
+
+	t.Run("Testando alteração direta da request", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		tc := (&TupaContext{}).NewTupaContext(httptest.NewRecorder(), req)
+
+		tc.request = tc.request.WithContext(context.WithValue(tc.request.Context(), "ctxText", "2602"))
+
+		if v := tc.Value("ctxText"); v != "2602" {
+			t.Errorf("esperava '2602', recebeu %v", v)
+		}
+	})
+
+	t.Run("Testando troca da request", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		tc := (&TupaContext{}).NewTupaContext(httptest.NewRecorder(), req)
+
+		tc.SetRequest(tc.request.WithContext(context.WithValue(tc.request.Context(), "ctxText", "2602")))
+
+		if v := tc.Value("ctxText"); v != "2602" {
+			t.Errorf("esperava '2602', recebeu %v", v)
+		}
+	})
+}
@@ -1,70 +1,187 @@
 package main
 
-import "sync"
+import (
+	"net/http"
+	"path"
+	"strings"
+)
 
 // MIDDLEWARE LOGIC
 
 type MiddlewareFunc func(APIFunc) APIFunc
 
-type MiddlewareChain []MiddlewareFunc
+// Middleware envolve um APIFunc. MiddlewareFunc é a forma comum; Named devolve um
+// *NamedMiddleware, que além de envolver o handler carrega nome e restrições de ordem
+type Middleware interface {
+	Wrap(next APIFunc) APIFunc
+}
+
+func (m MiddlewareFunc) Wrap(next APIFunc) APIFunc {
+	return m(next)
+}
 
-func (chain *MiddlewareChain) Use(middleware ...MiddlewareFunc) {
+type MiddlewareChain []Middleware
+
+func (chain *MiddlewareChain) Use(middleware ...Middleware) {
 	*chain = append(*chain, middleware...)
 }
 
-func (a *APIServer) UseGlobalMiddleware(middleware ...MiddlewareFunc) {
+// UseGlobalMiddleware adiciona middlewares a todas as rotas, inclusive as já registradas.
+// Precisa ser chamado antes de iniciar o servidor
+func (a *APIServer) UseGlobalMiddleware(middleware ...Middleware) {
+	if a.started.Load() {
+		panic("UseGlobalMiddleware precisa ser chamado antes de iniciar o servidor")
+	}
 	a.globalMiddlewares.Use(middleware...)
+
+	// as chains das rotas já registradas são remontadas com os novos middlewares
+	a.rebuildHandlers()
 }
 
-func (a *APIServer) GetGlobalMiddlewares() []MiddlewareFunc {
+func (a *APIServer) GetGlobalMiddlewares() []Middleware {
 	return a.globalMiddlewares
 }
 
-// Chain of responsibility
-func (chain *MiddlewareChain) execute(tc *TupaContext) error {
-	for _, middleware := range *chain {
-		// Cada middleware recebe um função next de argumento ( do tipo func(APIFunc) APIFunc)
-		// Cada função next é esperado que seja um middleware por si próprio
-		next := middleware(func(tc *TupaContext) error {
-			return nil
-		})
+// Then compõe a chain em volta do handler, como camadas de uma cebola: o primeiro middleware
+// é o mais externo e o next de cada um chama o próximo, até chegar no handler. Código antes
+// de next roda antes do handler e código depois de next (ou em defer) roda depois dele.
+// Depois de tc.Abort, chamar next não faz nada
+func (chain MiddlewareChain) Then(handler APIFunc) APIFunc {
+	for i := len(chain) - 1; i >= 0; i-- {
+		handler = chain[i].Wrap(skipIfAborted(handler))
+	}
+	return handler
+}
 
-		// chamando a função retornada por middleware(...)
-		if err := next(tc); err != nil {
-			// se qualquer middleware na chain tiver erro vai retornar o erro
-			return err
+func skipIfAborted(next APIFunc) APIFunc {
+	return func(tc *TupaContext) error {
+		if tc.aborted {
+			return nil
 		}
+		return next(tc)
+	}
+}
+
+// SHORT-CIRCUIT
+
+// Um middleware que já respondeu (cache, redirect de login) encerra a request simplesmente
+// não chamando next e retornando nil. Abort serve para quando o código que decide parar
+// não controla a chamada de next, por exemplo um helper ou um middleware genérico
+
+// Abort impede que os próximos middlewares e o handler rodem. Os middlewares externos,
+// que já estão executando, continuam normalmente depois do next
+func (tc *TupaContext) Abort() {
+	tc.aborted = true
+}
+
+func (tc *TupaContext) IsAborted() bool {
+	return tc.aborted
+}
+
+// AbortWithStatus envia só o status e encerra a request
+func (tc *TupaContext) AbortWithStatus(status int) {
+	tc.Abort()
+	tc.Writer().WriteHeader(status)
+}
+
+// AbortWithJSON envia v como JSON e encerra a request
+func (tc *TupaContext) AbortWithJSON(status int, v any) error {
+	tc.Abort()
+	return WriteJSONHelper(tc.Writer(), status, v)
+}
+
+// Redirect envia um redirect e encerra a request, útil em middlewares de login
+func (tc *TupaContext) Redirect(status int, url string) {
+	tc.Abort()
+	http.Redirect(tc.Writer(), tc.request, url, status)
+}
+
+// MIDDLEWARES CONDICIONAIS
+
+// MiddlewarePredicate decide, por request, se um middleware condicional deve rodar.
+// route é a rota que atendeu a request, com Path (o template registrado) e Meta
+type MiddlewarePredicate func(tc *TupaContext, route *RouteInfo) bool
+
+// When aplica os middlewares só quando predicate retorna true. Caso contrário a request
+// segue direto para o próximo middleware da chain. Com um único middleware o nome e as
+// restrições de ordem dele são mantidos, então ExcludeMiddlewares e Replace continuam achando
+//
+//	server.UseGlobalMiddleware(When(func(tc *TupaContext, route *RouteInfo) bool {
+//		return route.Meta["auth"] != false
+//	}, MiddlewareFunc(AuthMiddleware)))
+func When(predicate MiddlewarePredicate, middlewares ...Middleware) *NamedMiddleware {
+	return conditional("when", predicate, middlewares)
+}
+
+func conditional(kind string, predicate MiddlewarePredicate, middlewares MiddlewareChain) *NamedMiddleware {
+	info := MiddlewareInfo{Name: kind + "(" + strings.Join(middlewares.Names(), ", ") + ")"}
+	if len(middlewares) == 1 {
+		info = MiddlewareInfoOf(middlewares[0])
 	}
 
-	return nil
+	return &NamedMiddleware{MiddlewareInfo: info, Middleware: func(next APIFunc) APIFunc {
+		wrapped := middlewares.Then(next)
+
+		return func(tc *TupaContext) error {
+			if predicate(tc, tc.route) {
+				return wrapped(tc)
+			}
+			return next(tc)
+		}
+	}}
+}
+
+// SkipRule descreve as requests em que um middleware não deve rodar. Paths e Patterns
+// são comparados tanto com o template da rota ("/users/{id}") quanto com o path da URL
+type SkipRule struct {
+	// Paths são comparados exatamente
+	Paths []string
+	// Prefixes são comparados com o início do path da URL
+	Prefixes []string
+	// Patterns usam a sintaxe de path.Match, em que * não atravessa "/"
+	Patterns []string
+	Methods  []HTTPMethod
 }
 
-func (a *APIServer) executeMiddlewaresAsync(ctx *TupaContext, middlewares ...MiddlewareChain) <-chan []error {
-	doneCh := make(chan []error, 1)
+// Skip aplica os middlewares em todas as requests, menos nas que casam com rule. Assim
+// como em When, um único middleware mantém o nome dele
+//
+//	server.UseGlobalMiddleware(Skip(SkipRule{Paths: []string{"/health"}, Methods: []HTTPMethod{MethodOptions}}, MiddlewareFunc(LoggingMiddleware)))
+func Skip(rule SkipRule, middlewares ...Middleware) *NamedMiddleware {
+	return conditional("skip", func(tc *TupaContext, route *RouteInfo) bool {
+		return !rule.matches(tc, route)
+	}, middlewares)
+}
 
-	var wg sync.WaitGroup
+func (rule SkipRule) matches(tc *TupaContext, route *RouteInfo) bool {
+	for _, method := range rule.Methods {
+		if string(method) == tc.request.Method {
+			return true
+		}
+	}
 
-	var errorsSlice []error
+	paths := []string{tc.request.URL.Path}
+	if route != nil && route.Path != "" {
+		paths = append(paths, route.Path)
+	}
 
-	// Executa cada middleware em uma goroutine separada
-	for _, middlewareChain := range middlewares {
-		wg.Add(1)
-		go func(chain MiddlewareChain) {
-			defer wg.Done()
+	for _, p := range paths {
+		if containsString(rule.Paths, p) {
+			return true
+		}
 
-			// Executa o middleware
-			if err := chain.execute(ctx); err != nil {
-				errorsSlice = append(errorsSlice, err)
+		for _, pattern := range rule.Patterns {
+			if ok, _ := path.Match(pattern, p); ok {
+				return true
 			}
-		}(middlewareChain)
+		}
 	}
 
-	// Começa goroutine para fechar os channels, um de cada vez ( vai enviar os valores um por vez, a medida que chegarem)
-	go func() {
-		wg.Wait()
-		doneCh <- errorsSlice
-		close(doneCh)
-	}()
+	for _, prefix := range rule.Prefixes {
+		if strings.HasPrefix(tc.request.URL.Path, prefix) {
+			return true
+		}
+	}
 
-	return doneCh
+	return false
 }
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MIDDLEWARES NOMEADOS
+
+// MiddlewareInfo descreve um middleware registrado com Named
+type MiddlewareInfo struct {
+	Name string
+	// Priority menor roda antes (mais externo). O padrão é 0 e, com prioridades iguais,
+	// vale a ordem de registro: globais, grupo e rota
+	Priority int
+	// Before e After são nomes de middlewares que devem rodar depois ou antes deste.
+	// Nomes que não estão na chain da rota são ignorados
+	Before []string
+	After  []string
+}
+
+type MiddlewareOption func(*MiddlewareInfo)
+
+func Priority(priority int) MiddlewareOption {
+	return func(info *MiddlewareInfo) {
+		info.Priority = priority
+	}
+}
+
+// Before faz o middleware rodar antes (por fora) dos middlewares com esses nomes
+func Before(names ...string) MiddlewareOption {
+	return func(info *MiddlewareInfo) {
+		info.Before = append(info.Before, names...)
+	}
+}
+
+// After faz o middleware rodar depois (por dentro) dos middlewares com esses nomes
+func After(names ...string) MiddlewareOption {
+	return func(info *MiddlewareInfo) {
+		info.After = append(info.After, names...)
+	}
+}
+
+// NamedMiddleware é um middleware com nome e restrições de ordem, criado por Named
+type NamedMiddleware struct {
+	MiddlewareInfo
+	Middleware MiddlewareFunc
+}
+
+func (m *NamedMiddleware) Wrap(next APIFunc) APIFunc {
+	return m.Middleware(next)
+}
+
+// With retorna uma cópia do middleware com mais restrições de ordem, útil para os middlewares
+// que já vêm nomeados, como SessionMiddleware(cfg).With(After("request_id"))
+func (m *NamedMiddleware) With(opts ...MiddlewareOption) *NamedMiddleware {
+	c := *m
+	c.Before = append([]string(nil), m.Before...)
+	c.After = append([]string(nil), m.After...)
+	for _, opt := range opts {
+		opt(&c.MiddlewareInfo)
+	}
+	return &c
+}
+
+// Named dá um nome e restrições de ordem a um middleware. O nome aparece em Routes e
+// pode ser usado para trocar ou remover o middleware de uma chain
+//
+//	server.UseGlobalMiddleware(
+//		SessionMiddleware(SessionConfig{}),
+//		Named("auth", AuthMiddleware, After("session")),
+//	)
+func Named(name string, middleware MiddlewareFunc, opts ...MiddlewareOption) *NamedMiddleware {
+	named := &NamedMiddleware{MiddlewareInfo: MiddlewareInfo{Name: name}, Middleware: middleware}
+	for _, opt := range opts {
+		opt(&named.MiddlewareInfo)
+	}
+	return named
+}
+
+// MiddlewareInfoOf retorna as informações do middleware. Middlewares sem Named usam
+// o nome da função
+func MiddlewareInfoOf(middleware Middleware) MiddlewareInfo {
+	var fn MiddlewareFunc
+	switch m := middleware.(type) {
+	case nil:
+		return MiddlewareInfo{}
+	case *NamedMiddleware:
+		return m.MiddlewareInfo
+	case MiddlewareFunc:
+		fn = m
+	default:
+		return MiddlewareInfo{Name: fmt.Sprintf("%T", middleware)}
+	}
+
+	if fn == nil {
+		return MiddlewareInfo{}
+	}
+
+	// "github.com/org/repo.LoggingMiddleware" vira "LoggingMiddleware"
+	name := getFunctionName(fn)
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.Index(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	return MiddlewareInfo{Name: name}
+}
+
+// Names retorna os nomes dos middlewares da chain, na ordem em que estão
+func (chain MiddlewareChain) Names() []string {
+	names := make([]string, len(chain))
+	for i, middleware := range chain {
+		names[i] = MiddlewareInfoOf(middleware).Name
+	}
+	return names
+}
+
+func (chain MiddlewareChain) String() string {
+	return strings.Join(chain.Names(), " -> ")
+}
+
+// Replace troca os middlewares com esse nome por middleware, retornando se algum foi trocado
+func (chain MiddlewareChain) Replace(name string, middleware Middleware) bool {
+	replaced := false
+	for i := range chain {
+		if MiddlewareInfoOf(chain[i]).Name == name {
+			chain[i] = middleware
+			replaced = true
+		}
+	}
+	return replaced
+}
+
+// Remove tira da chain os middlewares com esse nome, retornando se algum foi removido
+func (chain *MiddlewareChain) Remove(name string) bool {
+	kept := (*chain)[:0]
+	for _, middleware := range *chain {
+		if MiddlewareInfoOf(middleware).Name != name {
+			kept = append(kept, middleware)
+		}
+	}
+
+	removed := len(kept) != len(*chain)
+	clear((*chain)[len(kept):])
+	*chain = kept
+	return removed
+}
+
+// resolve ordena a chain respeitando Before e After e, entre os middlewares livres,
+// a prioridade e depois a ordem original. Retorna erro se as restrições formarem um ciclo
+func (chain MiddlewareChain) resolve() (MiddlewareChain, error) {
+	n := len(chain)
+	infos := make([]MiddlewareInfo, n)
+	byName := make(map[string][]int, n)
+	for i, middleware := range chain {
+		infos[i] = MiddlewareInfoOf(middleware)
+		byName[infos[i].Name] = append(byName[infos[i].Name], i)
+	}
+
+	// edges[i] são os middlewares que precisam rodar depois de i
+	edges := make([][]int, n)
+	pending := make([]int, n)
+	addEdge := func(from, to int) {
+		if from == to {
+			return
+		}
+		edges[from] = append(edges[from], to)
+		pending[to]++
+	}
+
+	for i, info := range infos {
+		for _, name := range info.Before {
+			for _, j := range byName[name] {
+				addEdge(i, j)
+			}
+		}
+		for _, name := range info.After {
+			for _, j := range byName[name] {
+				addEdge(j, i)
+			}
+		}
+	}
+
+	// um middleware que precisa rodar antes de outro herda a prioridade dele, senão
+	// uma prioridade baixa seria ignorada sempre que houvesse um Before apontando para ele
+	priority := make([]int, n)
+	for i, info := range infos {
+		priority[i] = info.Priority
+	}
+	for k := 0; k < n; k++ {
+		for i := range edges {
+			for _, j := range edges[i] {
+				priority[i] = min(priority[i], priority[j])
+			}
+		}
+	}
+
+	var ready []int
+	for i := range chain {
+		if pending[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	resolved := make(MiddlewareChain, 0, n)
+	for len(ready) > 0 {
+		sort.Slice(ready, func(a, b int) bool {
+			if priority[ready[a]] != priority[ready[b]] {
+				return priority[ready[a]] < priority[ready[b]]
+			}
+			return ready[a] < ready[b]
+		})
+
+		i := ready[0]
+		ready = ready[1:]
+		resolved = append(resolved, chain[i])
+
+		for _, j := range edges[i] {
+			pending[j]--
+			if pending[j] == 0 {
+				ready = append(ready, j)
+			}
+		}
+	}
+
+	if len(resolved) != n {
+		var cycle []string
+		for i := range chain {
+			if pending[i] > 0 {
+				cycle = append(cycle, infos[i].Name)
+			}
+		}
+		return nil, fmt.Errorf("ciclo nas restrições de ordem dos middlewares: %s", strings.Join(cycle, ", "))
+	}
+
+	return resolved, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNamedMiddlewares(t *testing.T) {
+	record := func(name string, calls *[]string) MiddlewareFunc {
+		return func(next APIFunc) APIFunc {
+			return func(tc *TupaContext) error {
+				*calls = append(*calls, name)
+				return next(tc)
+			}
+		}
+	}
+
+	t.Run("Testando nomes e fallback para o nome da função", func(t *testing.T) {
+		chain := MiddlewareChain{Named("auth", MiddlewareContrA), MiddlewareFunc(MiddlewareContrB)}
+
+		names := chain.Names()
+		if names[0] != "auth" || names[1] != "MiddlewareContrB" {
+			t.Errorf("nomes inesperados: %v", names)
+		}
+		if got := chain.String(); got != "auth -> MiddlewareContrB" {
+			t.Errorf("chain impressa errada: %q", got)
+		}
+	})
+
+	t.Run("Testando With em middleware já nomeado", func(t *testing.T) {
+		session := SessionMiddleware(SessionConfig{})
+		ordered := session.With(After("request_id"), Priority(5))
+
+		if info := MiddlewareInfoOf(ordered); info.Name != "session" || info.Priority != 5 || fmt.Sprint(info.After) != "[request_id]" {
+			t.Errorf("info inesperada: %+v", info)
+		}
+		if len(session.After) != 0 || session.Priority != 0 {
+			t.Errorf("With não deveria alterar o middleware original: %+v", session.MiddlewareInfo)
+		}
+	})
+
+	t.Run("Testando prioridade e restrições before/after", func(t *testing.T) {
+		var calls []string
+		server := NewAPIServer(":6969")
+		server.UseGlobalMiddleware(
+			Named("logging", record("logging", &calls), Priority(-10)),
+			Named("auth", record("auth", &calls), After("session")),
+		)
+
+		route := RouteInfo{
+			Path:   "/ordem",
+			Method: MethodGet,
+			Middlewares: []Middleware{
+				Named("session", record("session", &calls)),
+				Named("metrics", record("metrics", &calls), Before("logging")),
+			},
+			Handler: APIFunc(handleSendString),
+		}
+		server.MakeHTTPHandlerFuncHelper(route)(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ordem", nil))
+
+		expected := []string{"metrics", "logging", "session", "auth"}
+		if fmt.Sprint(calls) != fmt.Sprint(expected) {
+			t.Errorf("ordem errada: recebeu %v queria %v", calls, expected)
+		}
+
+		// o recover do servidor sempre fica por fora
+		if chain := server.Routes()[0].Chain; fmt.Sprint(chain) != fmt.Sprint(append([]string{"recover"}, expected...)) {
+			t.Errorf("chain da rota errada: recebeu %v queria %v", chain, expected)
+		}
+	})
+
+	t.Run("Testando ciclo nas restrições", func(t *testing.T) {
+		defer func() {
+			r := recover()
+			if r == nil || !strings.Contains(fmt.Sprint(r), "ciclo") {
+				t.Errorf("esperava panic com ciclo, recebeu %v", r)
+			}
+		}()
+
+		server := NewAPIServer(":6969")
+		server.MakeHTTPHandlerFuncHelper(RouteInfo{
+			Path:   "/ciclo",
+			Method: MethodGet,
+			Middlewares: []Middleware{
+				Named("a", MiddlewareContrA, Before("b")),
+				Named("b", MiddlewareContrB, Before("a")),
+			},
+			Handler: APIFunc(handleSendString),
+		})
+	})
+
+	t.Run("Testando Replace e Remove em um grupo", func(t *testing.T) {
+		var calls []string
+		group := MiddlewareChain{
+			Named("auth", record("auth", &calls)),
+			Named("cors", record("cors", &calls)),
+			Named("csrf", record("csrf", &calls)),
+		}
+
+		if !group.Replace("auth", Named("auth", record("auth-api-key", &calls))) {
+			t.Errorf("Replace deveria achar auth")
+		}
+		if !group.Remove("csrf") || group.Remove("csrf") {
+			t.Errorf("Remove deveria remover csrf só uma vez")
+		}
+
+		group.Then(handleSendString)(&TupaContext{
+			request:  httptest.NewRequest(http.MethodGet, "/", nil),
+			response: httptest.NewRecorder(),
+		})
+
+		if fmt.Sprint(calls) != "[auth-api-key cors]" {
+			t.Errorf("chain do grupo errada: %v", calls)
+		}
+	})
+
+	t.Run("Testando ExcludeMiddlewares e nomes de When/Skip", func(t *testing.T) {
+		server := NewAPIServer(":6969")
+		server.UseGlobalMiddleware(
+			Named("auth", MiddlewareContrA),
+			Skip(SkipRule{Paths: []string{"/health"}}, Named("logging", MiddlewareContrB)),
+			When(func(tc *TupaContext, route *RouteInfo) bool { return true }, Named("metrics", MiddlewareContrC), Named("audit", MiddlewareContrC)),
+		)
+
+		server.MakeHTTPHandlerFuncHelper(RouteInfo{
+			Path:               "/callback",
+			Method:             MethodGet,
+			ExcludeMiddlewares: []string{"auth"},
+			Handler:            APIFunc(handleSendString),
+		})
+		server.MakeHTTPHandlerFuncHelper(RouteInfo{
+			Path:               "/health",
+			Method:             MethodGet,
+			ExcludeMiddlewares: []string{"logging"},
+			Handler:            APIFunc(handleSendString),
+		})
+
+		routes := server.Routes()
+		if chain := routes[0].Chain; fmt.Sprint(chain) != "[recover logging when(metrics, audit)]" {
+			t.Errorf("chain da rota errada: %v", chain)
+		}
+		if chain := routes[1].Chain; fmt.Sprint(chain) != "[recover auth when(metrics, audit)]" {
+			t.Errorf("Skip deveria ser removido pelo nome do middleware interno: %v", chain)
+		}
+	})
+}
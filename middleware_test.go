@@ -1,23 +1,26 @@
 package main
 
 import (
-	"context"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 )
 
+var (
+	smpMiddKey = NewKey[string]("smpMidd")
+	withCtxKey = NewKey[string]("withCtx")
+)
+
 func MiddlewareSample(next APIFunc) APIFunc {
 
 	return func(tc *TupaContext) error {
-		smpMidd := "sampleMiddleware"
-		reqCtx := context.WithValue(tc.request.Context(), "smpMidd", smpMidd)
-		tc.request = tc.request.WithContext(reqCtx)
+		smpMiddKey.Set(tc, "sampleMiddleware")
 
 		log.SetFlags(log.LstdFlags | log.Lmicroseconds)
 		fmt.Println("Middleware antes de chamar o handler")
@@ -29,7 +32,11 @@ func MiddlewareSample(next APIFunc) APIFunc {
 }
 
 func getCtxFromSampleMiddleware(tc *TupaContext) {
-	ctxValue := tc.request.Context().Value("smpMidd").(string)
+	ctxValue, ok := smpMiddKey.Get(tc)
+	if !ok {
+		fmt.Println("smpMidd não encontrado na request")
+		return
+	}
 
 	fmt.Println(ctxValue)
 }
@@ -39,8 +46,7 @@ func MiddlewareLoggingWithError(next APIFunc) APIFunc {
 
 		start := time.Now()
 		errMsg := errors.New("erro no middleware LoggingMiddlewareWithError")
-		ctx := context.WithValue(tc.request.Context(), "smpErrorMidd", "sampleErrorMiddleware")
-		tc.request = tc.request.WithContext(ctx)
+		smpErrorMiddKey.Set(tc, "sampleErrorMiddleware")
 
 		err := next(tc)
 		log.SetFlags(log.LstdFlags | log.Lmicroseconds)
@@ -57,9 +63,7 @@ func MiddlewareLoggingWithError(next APIFunc) APIFunc {
 func MiddlewareWithCtx(next APIFunc) APIFunc {
 
 	return func(tc *TupaContext) error {
-		smpMidd := "MiddlewareWithCtx"
-		reqCtx := context.WithValue(tc.request.Context(), "withCtx", smpMidd)
-		tc.request = tc.request.WithContext(reqCtx)
+		withCtxKey.Set(tc, "MiddlewareWithCtx")
 
 		log.SetFlags(log.LstdFlags | log.Lmicroseconds)
 
@@ -70,9 +74,7 @@ func MiddlewareWithCtx(next APIFunc) APIFunc {
 func MiddlewareWithCtxChanMsg(next APIFunc, messages chan<- string) APIFunc {
 
 	return func(tc *TupaContext) error {
-		smpMidd := "MiddlewareWithCtx"
-		reqCtx := context.WithValue(tc.request.Context(), "withCtx", smpMidd)
-		tc.request = tc.request.WithContext(reqCtx)
+		withCtxKey.Set(tc, "MiddlewareWithCtx")
 
 		log.SetFlags(log.LstdFlags | log.Lmicroseconds)
 
@@ -95,6 +97,9 @@ func TestSampleMiddleware(t *testing.T) {
 		// handler que não retorna erro
 		handler := func(tc *TupaContext) error {
 			// Chacando se o middleware tem valor de context
+			if val, ok := tc.Get("qualquerKey"); ok {
+				t.Errorf("valor %v não era esperado", val)
+			}
 			if val := tc.request.Context().Value("qualquerKey"); val != nil {
 				t.Error("Valor de context esperado não era esperado")
 			}
@@ -123,7 +128,7 @@ func TestMiddlewareConcurrency(t *testing.T) {
 		middleware := MiddlewareWithCtx
 
 		handler := func(tc *TupaContext) error {
-			ctxValue := tc.request.Context().Value("withCtx").(string)
+			ctxValue, _ := withCtxKey.Get(tc)
 
 			if ctxValue != "MiddlewareWithCtx" {
 				t.Errorf("Esperava valor de context 'MiddlewareWithCtx', recebeu '%s'", ctxValue)
@@ -167,7 +172,7 @@ func TestMiddlewareWithCtxAndChannels(t *testing.T) {
 	middleware := MiddlewareWithCtx
 
 	handler := func(tc *TupaContext) error {
-		ctxValue := tc.request.Context().Value("withCtx").(string)
+		ctxValue, _ := withCtxKey.Get(tc)
 
 		ctxValues <- ctxValue
 
@@ -249,55 +254,395 @@ func TestMiddlewareWithCtxAndChannelAndMsg(t *testing.T) {
 	}
 }
 
-func middlewareSuccess(next APIFunc) APIFunc {
-	return func(tc *TupaContext) error {
-		return nil
+func TestMiddlewareOnion(t *testing.T) {
+	recordMiddleware := func(name string, calls *[]string) MiddlewareFunc {
+		return func(next APIFunc) APIFunc {
+			return func(tc *TupaContext) error {
+				*calls = append(*calls, name+" antes")
+				defer func() { *calls = append(*calls, name+" depois") }()
+				return next(tc)
+			}
+		}
 	}
+
+	t.Run("Testando ordem global, grupo e rota em volta do handler", func(t *testing.T) {
+		var calls []string
+		server := NewAPIServer(":6969")
+		server.UseGlobalMiddleware(recordMiddleware("global", &calls))
+
+		allRoutes = nil
+		defer func() { allRoutes = nil }()
+		AddRoutes(MiddlewareChain{recordMiddleware("grupo", &calls)}, func() []RouteInfo {
+			return []RouteInfo{{
+				Path:        "/onion",
+				Method:      MethodGet,
+				Middlewares: []Middleware{recordMiddleware("rota", &calls)},
+				Handler: APIFunc(func(tc *TupaContext) error {
+					calls = append(calls, "handler")
+					return tc.SendString("ok")
+				}),
+			}}
+		})
+
+		route := GetRoutes()[0]
+		req := httptest.NewRequest(http.MethodGet, "/onion", nil)
+		w := httptest.NewRecorder()
+		server.MakeHTTPHandlerFuncHelper(route)(w, req)
+
+		expected := []string{"global antes", "grupo antes", "rota antes", "handler", "rota depois", "grupo depois", "global depois"}
+		if fmt.Sprint(calls) != fmt.Sprint(expected) {
+			t.Errorf("ordem errada: recebeu %v queria %v", calls, expected)
+		}
+	})
+
+	t.Run("Testando middleware que vê e transforma o erro do handler", func(t *testing.T) {
+		var handlerErr error
+		server := NewAPIServer(":6969")
+		server.UseGlobalMiddleware(MiddlewareFunc(func(next APIFunc) APIFunc {
+			return func(tc *TupaContext) error {
+				handlerErr = next(tc)
+				if handlerErr != nil {
+					return NewHTTPError(http.StatusTeapot, "").Wrap(handlerErr)
+				}
+				return nil
+			}
+		}))
+
+		req := httptest.NewRequest(http.MethodGet, "/erro", nil)
+		w := httptest.NewRecorder()
+		server.MakeHTTPHandlerFuncHelper(RouteInfo{
+			Path:   "/erro",
+			Method: MethodGet,
+			Handler: APIFunc(func(tc *TupaContext) error {
+				return errors.New("falha no handler")
+			}),
+		})(w, req)
+
+		if handlerErr == nil || handlerErr.Error() != "falha no handler" {
+			t.Errorf("middleware deveria receber o erro do handler, recebeu %v", handlerErr)
+		}
+		if w.Code != http.StatusTeapot {
+			t.Errorf("status errado: recebeu %d queria %d", w.Code, http.StatusTeapot)
+		}
+	})
+
+	t.Run("Testando middleware que não chama next", func(t *testing.T) {
+		called := false
+		server := NewAPIServer(":6969")
+		server.UseGlobalMiddleware(MiddlewareFunc(MiddlewareSampleErr))
+
+		req := httptest.NewRequest(http.MethodGet, "/bloqueado", nil)
+		w := httptest.NewRecorder()
+		server.MakeHTTPHandlerFuncHelper(RouteInfo{
+			Path:   "/bloqueado",
+			Method: MethodGet,
+			Handler: APIFunc(func(tc *TupaContext) error {
+				called = true
+				return nil
+			}),
+		})(w, req)
+
+		if called {
+			t.Errorf("handler não deveria ser chamado")
+		}
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("status errado: recebeu %d queria %d", w.Code, http.StatusInternalServerError)
+		}
+	})
+
+	t.Run("Testando middleware global adicionado depois do registro", func(t *testing.T) {
+		var calls []string
+		server := NewAPIServer(":6969")
+		handler := server.MakeHTTPHandlerFuncHelper(RouteInfo{
+			Path:   "/tarde",
+			Method: MethodGet,
+			Handler: APIFunc(func(tc *TupaContext) error {
+				calls = append(calls, "handler")
+				return nil
+			}),
+		})
+		server.UseGlobalMiddleware(recordMiddleware("global", &calls))
+
+		handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/tarde", nil))
+
+		expected := []string{"global antes", "handler", "global depois"}
+		if fmt.Sprint(calls) != fmt.Sprint(expected) {
+			t.Errorf("ordem errada: recebeu %v queria %v", calls, expected)
+		}
+	})
+
+	t.Run("Testando middleware global depois de iniciar o servidor", func(t *testing.T) {
+		server := NewAPIServer(":6969")
+		server.MakeHTTPHandlerFuncHelper(RouteInfo{Path: "/", Method: MethodGet, Handler: APIFunc(handleSendString)})
+		server.started.Store(true)
+
+		defer func() {
+			if recover() == nil {
+				t.Errorf("esperava panic ao remontar as chains com o servidor rodando")
+			}
+		}()
+		server.UseGlobalMiddleware(recordMiddleware("global", new([]string)))
+	})
 }
 
-// Define a middleware function that always fails
-func middlewareFailure(ctx APIFunc) APIFunc {
-	return func(tc *TupaContext) error {
-		return errors.New("middleware failed")
+func TestShortCircuit(t *testing.T) {
+	serve := func(middlewares ...MiddlewareFunc) (*httptest.ResponseRecorder, bool) {
+		called := false
+		server := NewAPIServer(":6969")
+		for _, middleware := range middlewares {
+			server.UseGlobalMiddleware(middleware)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/curto", nil)
+		w := httptest.NewRecorder()
+		server.MakeHTTPHandlerFuncHelper(RouteInfo{
+			Path:   "/curto",
+			Method: MethodGet,
+			Handler: APIFunc(func(tc *TupaContext) error {
+				called = true
+				return tc.SendString("handler")
+			}),
+		})(w, req)
+
+		return w, called
 	}
+
+	t.Run("Testando middleware que responde sem chamar next", func(t *testing.T) {
+		w, called := serve(func(next APIFunc) APIFunc {
+			return func(tc *TupaContext) error {
+				return tc.SendString("cache")
+			}
+		})
+
+		if called {
+			t.Errorf("handler não deveria ser chamado")
+		}
+		if w.Code != http.StatusOK || w.Body.String() != "cache" {
+			t.Errorf("resposta errada: %d %q", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("Testando Abort antes de next", func(t *testing.T) {
+		var innerCalled, outerAfter bool
+		outer := func(next APIFunc) APIFunc {
+			return func(tc *TupaContext) error {
+				err := next(tc)
+				outerAfter = true
+				return err
+			}
+		}
+		abort := func(next APIFunc) APIFunc {
+			return func(tc *TupaContext) error {
+				tc.Redirect(http.StatusFound, "/login")
+				return next(tc)
+			}
+		}
+		inner := func(next APIFunc) APIFunc {
+			return func(tc *TupaContext) error {
+				innerCalled = true
+				return next(tc)
+			}
+		}
+
+		w, called := serve(outer, abort, inner)
+
+		if called || innerCalled {
+			t.Errorf("middlewares internos e handler não deveriam rodar depois do Abort")
+		}
+		if !outerAfter {
+			t.Errorf("middleware externo deveria continuar depois do next")
+		}
+		if w.Code != http.StatusFound || w.Header().Get("Location") != "/login" {
+			t.Errorf("esperava redirect para /login, recebeu %d %q", w.Code, w.Header().Get("Location"))
+		}
+	})
+
+	t.Run("Testando resposta enviada seguida de erro", func(t *testing.T) {
+		w, called := serve(func(next APIFunc) APIFunc {
+			return func(tc *TupaContext) error {
+				tc.AbortWithJSON(http.StatusAccepted, map[string]string{"status": "na fila"})
+				return errors.New("erro depois da resposta")
+			}
+		})
+
+		if called {
+			t.Errorf("handler não deveria ser chamado")
+		}
+		if w.Code != http.StatusAccepted || strings.Contains(w.Body.String(), "Error") {
+			t.Errorf("não deveria haver um segundo body de erro: %d %q", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("Testando middleware que responde e ainda chama next", func(t *testing.T) {
+		w, called := serve(func(next APIFunc) APIFunc {
+			return func(tc *TupaContext) error {
+				tc.AbortWithStatus(http.StatusNoContent)
+				return next(tc)
+			}
+		})
+
+		if called || w.Code != http.StatusNoContent {
+			t.Errorf("handler não deveria rodar depois da resposta, recebeu %d", w.Code)
+		}
+	})
+
+	t.Run("Testando Early Hints antes do handler", func(t *testing.T) {
+		server := NewAPIServer(":6969")
+		server.UseGlobalMiddleware(MiddlewareFunc(func(next APIFunc) APIFunc {
+			return func(tc *TupaContext) error {
+				tc.Writer().Header().Set("Link", "</style.css>; rel=preload")
+				tc.Writer().WriteHeader(http.StatusEarlyHints)
+				return next(tc)
+			}
+		}))
+
+		rec := &informationalRecorder{ResponseRecorder: httptest.NewRecorder()}
+		server.MakeHTTPHandlerFuncHelper(RouteInfo{
+			Path:   "/hints",
+			Method: MethodGet,
+			Handler: APIFunc(func(tc *TupaContext) error {
+				return tc.SendString("handler")
+			}),
+		})(rec, httptest.NewRequest(http.MethodGet, "/hints", nil))
+
+		if rec.Code != http.StatusOK || rec.Body.String() != "handler" {
+			t.Errorf("handler deveria rodar depois do 103, recebeu %d %q", rec.Code, rec.Body.String())
+		}
+		if len(rec.statuses) != 2 || rec.statuses[0] != http.StatusEarlyHints {
+			t.Errorf("status enviados errados: %v", rec.statuses)
+		}
+	})
 }
 
-func TestExecuteMiddlewaresAsync_NoErrors(t *testing.T) {
-	t.Run("Testando ExecuteMiddlewaresAsync sem erros", func(t *testing.T) {
-		server := NewAPIServer(":8080")
+func TestConditionalMiddleware(t *testing.T) {
+	countMiddleware := func(count *int) MiddlewareFunc {
+		return func(next APIFunc) APIFunc {
+			return func(tc *TupaContext) error {
+				*count++
+				return next(tc)
+			}
+		}
+	}
 
-		ctx := &TupaContext{}
+	serve := func(server *APIServer, route RouteInfo, method, target string) *httptest.ResponseRecorder {
+		if route.Handler == nil {
+			route.Handler = APIFunc(handleSendString)
+		}
+		if route.Method == "" {
+			route.Method = MethodGet
+		}
 
-		// Definir middleware que sempre retorna sucesso
-		middlewareSuccess := MiddlewareChain{middlewareSuccess}
+		w := httptest.NewRecorder()
+		server.MakeHTTPHandlerFuncHelper(route)(w, httptest.NewRequest(method, target, nil))
+		return w
+	}
 
-		// Execute os middlewares de forma assíncrona
-		doneCh := server.executeMiddlewaresAsync(ctx, middlewareSuccess)
+	t.Run("Testando When com metadados da rota", func(t *testing.T) {
+		count := 0
+		server := NewAPIServer(":6969")
+		server.UseGlobalMiddleware(When(func(tc *TupaContext, route *RouteInfo) bool {
+			return route.Meta["auth"] == true
+		}, countMiddleware(&count)))
 
-		// esperando executar
-		errorsSlice := <-doneCh
+		serve(server, RouteInfo{Path: "/privado", Meta: map[string]any{"auth": true}}, http.MethodGet, "/privado")
+		serve(server, RouteInfo{Path: "/publico"}, http.MethodGet, "/publico")
 
-		if len(errorsSlice) != 0 {
-			t.Errorf("não esperava erros, recebeu %d erros", len(errorsSlice))
+		if count != 1 {
+			t.Errorf("middleware deveria rodar só na rota com auth, rodou %d vezes", count)
 		}
 	})
 
-	t.Run("Testando ExecuteMiddlewaresAsync com erros", func(t *testing.T) {
-		server := NewAPIServer(":8080")
+	t.Run("Testando Skip por path, prefixo, padrão e método", func(t *testing.T) {
+		count := 0
+		server := NewAPIServer(":6969")
+		server.UseGlobalMiddleware(Skip(SkipRule{
+			Paths:    []string{"/health"},
+			Prefixes: []string{"/static/"},
+			Patterns: []string{"/users/{id}/avatar", "/v*/ping"},
+			Methods:  []HTTPMethod{MethodPost},
+		}, countMiddleware(&count)))
+
+		skipped := []struct {
+			route  RouteInfo
+			method string
+			target string
+		}{
+			{RouteInfo{Path: "/health"}, http.MethodGet, "/health"},
+			{RouteInfo{Path: "/static/{name}"}, http.MethodGet, "/static/a/b.png"},
+			{RouteInfo{Path: "/users/{id}/avatar"}, http.MethodGet, "/users/42/avatar"},
+			{RouteInfo{Path: "/v2/ping"}, http.MethodGet, "/v2/ping"},
+			{RouteInfo{Path: "/users", Method: MethodPost}, http.MethodPost, "/users"},
+		}
+		for _, req := range skipped {
+			serve(server, req.route, req.method, req.target)
+		}
 
-		ctx := &TupaContext{}
+		if count != 0 {
+			t.Errorf("middleware não deveria rodar nas rotas ignoradas, rodou %d vezes", count)
+		}
 
-		// Definir middleware que sempre retorna falha
-		middlewareFailure := MiddlewareChain{middlewareFailure}
+		serve(server, RouteInfo{Path: "/users"}, http.MethodGet, "/users")
+		if count != 1 {
+			t.Errorf("middleware deveria rodar fora das regras, rodou %d vezes", count)
+		}
+	})
+
+	t.Run("Testando ExcludeGlobal", func(t *testing.T) {
+		globalCount, routeCount := 0, 0
+		server := NewAPIServer(":6969")
+		server.UseGlobalMiddleware(countMiddleware(&globalCount))
+
+		serve(server, RouteInfo{
+			Path:          "/health",
+			ExcludeGlobal: true,
+			Middlewares:   []Middleware{countMiddleware(&routeCount)},
+		}, http.MethodGet, "/health")
 
-		// Executando os middlewares de forma assíncrona
-		doneCh := server.executeMiddlewaresAsync(ctx, middlewareFailure)
+		if globalCount != 0 || routeCount != 1 {
+			t.Errorf("esperava só o middleware da rota, global %d rota %d", globalCount, routeCount)
+		}
+	})
+}
+
+func TestRequestStore(t *testing.T) {
+	t.Run("Testando chaves tipadas", func(t *testing.T) {
+		type User struct{ Name string }
+		userKey := NewKey[User]("user")
+		tc := &TupaContext{}
+
+		if _, ok := userKey.Get(tc); ok {
+			t.Errorf("não esperava valor antes do Set")
+		}
+
+		userKey.Set(tc, User{Name: "Victor"})
+		user, ok := userKey.Get(tc)
+		if !ok || user.Name != "Victor" {
+			t.Errorf("esperava usuário Victor, recebeu %+v", user)
+		}
+	})
+
+	t.Run("Testando chaves com o mesmo nome não colidem", func(t *testing.T) {
+		a := NewKey[string]("user")
+		b := NewKey[int]("user")
+		tc := &TupaContext{}
+
+		a.Set(tc, "Victor")
+		tc.Set("user", "string key")
+
+		if _, ok := b.Get(tc); ok {
+			t.Errorf("chave b não deveria ter valor")
+		}
+		if v, _ := a.Get(tc); v != "Victor" {
+			t.Errorf("esperava 'Victor', recebeu '%s'", v)
+		}
+	})
 
-		// esperando executar
-		errorsSlice := <-doneCh
+	t.Run("Testando Values para debug", func(t *testing.T) {
+		tc := &TupaContext{}
+		NewKey[int]("idade").Set(tc, 24)
 
-		if len(errorsSlice) == 0 {
-			t.Errorf("expected erros mas não recebeu nenhum")
+		if v, ok := tc.Values()["idade (int)"]; !ok || v != 24 {
+			t.Errorf("esperava 'idade (int)' = 24 em %v", tc.Values())
 		}
 	})
 }
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// PAGINAÇÃO, ORDENAÇÃO E FILTROS
+
+const (
+	DefaultPerPage = 20
+	DefaultMaxPage = 100
+)
+
+type Page struct {
+	Number  int
+	PerPage int
+}
+
+// Offset retorna quantos registros pular, para usar direto em OFFSET
+func (p Page) Offset() int {
+	return (p.Number - 1) * p.PerPage
+}
+
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// Sort é a lista de campos de ordenação, na ordem em que vieram em ?sort=-created_at,name
+type Sort []SortField
+
+// Filter guarda os valores de ?filter[campo]=valor, agrupados pelo campo
+type Filter map[string][]string
+
+type ListQuery struct {
+	Page   Page
+	Sort   Sort
+	Filter Filter
+}
+
+// ListOptions define os limites aceitos por ListQuery. Só campos presentes em
+// SortFields e FilterFields são aceitos, já que eles costumam ir parar em queries SQL
+type ListOptions struct {
+	DefaultPerPage int
+	MaxPerPage     int
+	SortFields     []string
+	FilterFields   []string
+}
+
+// ListQuery lê page, per_page, sort e filter[campo] da query string.
+// per_page acima do máximo é limitado ao máximo; valores inválidos retornam *BindError
+func (tc *TupaContext) ListQuery(opts ListOptions) (ListQuery, error) {
+	if opts.DefaultPerPage <= 0 {
+		opts.DefaultPerPage = DefaultPerPage
+	}
+	if opts.MaxPerPage <= 0 {
+		opts.MaxPerPage = DefaultMaxPage
+	}
+
+	query := tc.request.URL.Query()
+	list := ListQuery{
+		Page:   Page{Number: 1, PerPage: opts.DefaultPerPage},
+		Filter: Filter{},
+	}
+
+	if raw := query.Get("page"); raw != "" {
+		number, err := strconv.Atoi(raw)
+		if err != nil || number < 1 {
+			return ListQuery{}, &BindError{Field: "page", Value: raw, Err: errors.New("precisa ser um inteiro maior que zero")}
+		}
+		list.Page.Number = number
+	}
+
+	if raw := query.Get("per_page"); raw != "" {
+		perPage, err := strconv.Atoi(raw)
+		if err != nil || perPage < 1 {
+			return ListQuery{}, &BindError{Field: "per_page", Value: raw, Err: errors.New("precisa ser um inteiro maior que zero")}
+		}
+		list.Page.PerPage = min(perPage, opts.MaxPerPage)
+	}
+
+	// páginas absurdas estourariam o int em Offset
+	if list.Page.Number > math.MaxInt/list.Page.PerPage {
+		return ListQuery{}, &BindError{Field: "page", Value: query.Get("page"), Err: errors.New("página fora do intervalo permitido")}
+	}
+
+	for _, raw := range headerList(query["sort"]) {
+		field := SortField{Field: strings.TrimPrefix(raw, "-"), Desc: strings.HasPrefix(raw, "-")}
+		if !containsString(opts.SortFields, field.Field) {
+			return ListQuery{}, &BindError{Field: "sort", Value: raw, Err: errors.New("campo de ordenação não permitido")}
+		}
+		list.Sort = append(list.Sort, field)
+	}
+
+	for key, values := range query {
+		if !strings.HasPrefix(key, "filter[") || !strings.HasSuffix(key, "]") {
+			continue
+		}
+
+		field := key[len("filter[") : len(key)-1]
+		if !containsString(opts.FilterFields, field) {
+			return ListQuery{}, &BindError{Field: key, Err: errors.New("campo de filtro não permitido")}
+		}
+		list.Filter[field] = append(list.Filter[field], headerList(values)...)
+	}
+
+	return list, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// SetPageHeaders escreve X-Total-Count e o header Link (RFC 8288) com as páginas
+// first, prev, next e last, mantendo os outros parâmetros da query
+func (tc *TupaContext) SetPageHeaders(page Page, total int) {
+	header := tc.response.Header()
+	header.Set("X-Total-Count", strconv.Itoa(total))
+
+	lastPage := 1
+	if page.PerPage > 0 && total > 0 {
+		lastPage = (total + page.PerPage - 1) / page.PerPage
+	}
+
+	links := []string{tc.pageLink(page, 1, "first")}
+	if page.Number > 1 {
+		links = append(links, tc.pageLink(page, min(page.Number-1, lastPage), "prev"))
+	}
+	if page.Number < lastPage {
+		links = append(links, tc.pageLink(page, page.Number+1, "next"))
+	}
+	links = append(links, tc.pageLink(page, lastPage, "last"))
+
+	header.Set("Link", strings.Join(links, ", "))
+}
+
+func (tc *TupaContext) pageLink(page Page, number int, rel string) string {
+	query := tc.request.URL.Query()
+	query.Set("page", strconv.Itoa(number))
+	query.Set("per_page", strconv.Itoa(page.PerPage))
+
+	u := url.URL{Path: tc.request.URL.Path, RawQuery: query.Encode()}
+	return fmt.Sprintf(`<%s>; rel="%s"`, tc.AbsoluteURL(u.RequestURI()), rel)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestListQuery(t *testing.T) {
+	opts := ListOptions{MaxPerPage: 50, SortFields: []string{"created_at", "name"}, FilterFields: []string{"status"}}
+
+	t.Run("Testando paginação, ordenação e filtros", func(t *testing.T) {
+		tc := newTestContext(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users?page=2&per_page=500&sort=-created_at,name&filter[status]=ativo,pendente", nil))
+
+		list, err := tc.ListQuery(opts)
+		if err != nil {
+			t.Fatalf("erro inesperado: %v", err)
+		}
+
+		if list.Page.Number != 2 || list.Page.PerPage != 50 || list.Page.Offset() != 50 {
+			t.Errorf("paginação incorreta: %+v", list.Page)
+		}
+		wantSort := Sort{{Field: "created_at", Desc: true}, {Field: "name"}}
+		if !reflect.DeepEqual(list.Sort, wantSort) {
+			t.Errorf("esperava ordenação %v, recebeu %v", wantSort, list.Sort)
+		}
+		if !reflect.DeepEqual(list.Filter["status"], []string{"ativo", "pendente"}) {
+			t.Errorf("filtro incorreto: %v", list.Filter)
+		}
+	})
+
+	t.Run("Testando página que estouraria o offset", func(t *testing.T) {
+		tc := newTestContext(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users?per_page=50&page="+strconv.Itoa(math.MaxInt/10), nil))
+
+		var bindErr *BindError
+		if _, err := tc.ListQuery(opts); !errors.As(err, &bindErr) || bindErr.Field != "page" {
+			t.Errorf("esperava BindError no campo page, recebeu %v", err)
+		}
+	})
+
+	t.Run("Testando campo de ordenação não permitido", func(t *testing.T) {
+		tc := newTestContext(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users?sort=password", nil))
+
+		var bindErr *BindError
+		if _, err := tc.ListQuery(opts); !errors.As(err, &bindErr) {
+			t.Errorf("esperava BindError, recebeu %v", err)
+		}
+	})
+
+	t.Run("Testando headers de paginação", func(t *testing.T) {
+		tc := newTestContext(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://api.local/users?page=2&per_page=10&name=victor", nil))
+
+		tc.SetPageHeaders(Page{Number: 2, PerPage: 10}, 35)
+
+		header := tc.response.Header()
+		if header.Get("X-Total-Count") != "35" {
+			t.Errorf("esperava X-Total-Count 35, recebeu %s", header.Get("X-Total-Count"))
+		}
+
+		link := header.Get("Link")
+		for _, want := range []string{
+			`<http://api.local/users?name=victor&page=1&per_page=10>; rel="first"`,
+			`<http://api.local/users?name=victor&page=1&per_page=10>; rel="prev"`,
+			`<http://api.local/users?name=victor&page=3&per_page=10>; rel="next"`,
+			`<http://api.local/users?name=victor&page=4&per_page=10>; rel="last"`,
+		} {
+			if !strings.Contains(link, want) {
+				t.Errorf("header Link %q não contém %q", link, want)
+			}
+		}
+	})
+}
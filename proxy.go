@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// PROXIES CONFIÁVEIS
+
+// SetTrustedProxies define os proxies (IPs ou CIDRs) cujos headers Forwarded, X-Forwarded-*
+// e X-Real-IP são respeitados. Requests vindas de qualquer outro endereço têm esses headers ignorados
+//
+//	server.SetTrustedProxies("127.0.0.1", "10.0.0.0/8")
+func (a *APIServer) SetTrustedProxies(proxies ...string) error {
+	var nets []*net.IPNet
+
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return fmt.Errorf("proxy confiável inválido: %s", proxy)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return fmt.Errorf("proxy confiável inválido: %s: %w", proxy, err)
+		}
+		nets = append(nets, ipNet)
+	}
+
+	a.trustedProxies = nets
+	return nil
+}
+
+func (a *APIServer) isTrustedProxy(ip net.IP) bool {
+	if a == nil || ip == nil {
+		return false
+	}
+
+	for _, ipNet := range a.trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedHop é um salto registrado por um proxy: quem era o cliente dele,
+// por qual protocolo e para qual host a request chegou
+type forwardedHop struct {
+	forIP string
+	proto string
+	host  string
+}
+
+// trustedHop percorre os saltos da direita para a esquerda, começando pelo peer direto,
+// e para no primeiro salto registrado por um proxy confiável cujo cliente não é confiável.
+// Saltos à esquerda dele podem ter sido forjados pelo cliente
+func (tc *TupaContext) trustedHop() (forwardedHop, bool) {
+	remote := net.ParseIP(remoteHost(tc.request.RemoteAddr))
+	if !tc.server.isTrustedProxy(remote) {
+		return forwardedHop{}, false
+	}
+
+	hops := parseForwarded(tc.request.Header.Values("Forwarded"))
+	if len(hops) == 0 {
+		for _, ip := range headerList(tc.request.Header.Values("X-Forwarded-For")) {
+			hops = append(hops, forwardedHop{forIP: ip})
+		}
+	}
+
+	if len(hops) == 0 {
+		if realIP := strings.TrimSpace(tc.request.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+			return forwardedHop{forIP: realIP}, true
+		}
+		return forwardedHop{}, true
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(hops[i].forIP)
+		if ip == nil {
+			// endereço ofuscado ou inválido, não dá para confiar em nada antes dele
+			return forwardedHop{proto: hops[i].proto, host: hops[i].host}, true
+		}
+
+		if i == 0 || !tc.server.isTrustedProxy(ip) {
+			return hops[i], true
+		}
+	}
+
+	return forwardedHop{}, true
+}
+
+// RealIP retorna o IP do cliente, levando em conta os proxies confiáveis
+func (tc *TupaContext) RealIP() string {
+	if hop, ok := tc.trustedHop(); ok && hop.forIP != "" {
+		return hop.forIP
+	}
+
+	return remoteHost(tc.request.RemoteAddr)
+}
+
+// Scheme retorna "https" ou "http" conforme a request original do cliente
+func (tc *TupaContext) Scheme() string {
+	if hop, ok := tc.trustedHop(); ok {
+		proto := hop.proto
+		if proto == "" {
+			proto = lastHeaderValue(tc.request.Header.Values("X-Forwarded-Proto"))
+		}
+
+		if proto = strings.ToLower(proto); proto == "https" || proto == "http" {
+			return proto
+		}
+	}
+
+	if tc.request.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// Host retorna o host pedido pelo cliente, levando em conta os proxies confiáveis
+func (tc *TupaContext) Host() string {
+	if hop, ok := tc.trustedHop(); ok {
+		host := hop.host
+		if host == "" {
+			host = lastHeaderValue(tc.request.Header.Values("X-Forwarded-Host"))
+		}
+
+		if host != "" {
+			return host
+		}
+	}
+
+	return tc.request.Host
+}
+
+// IsTLS informa se o cliente fez a request por HTTPS, mesmo que o TLS termine no proxy
+func (tc *TupaContext) IsTLS() bool {
+	return tc.Scheme() == "https"
+}
+
+// AbsoluteURL monta uma URL absoluta para path usando o scheme e host vistos pelo cliente
+func (tc *TupaContext) AbsoluteURL(path string) string {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	return tc.Scheme() + "://" + tc.Host() + path
+}
+
+func remoteHost(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+func headerList(values []string) []string {
+	var list []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+func lastHeaderValue(values []string) string {
+	list := headerList(values)
+	if len(list) == 0 {
+		return ""
+	}
+	return list[len(list)-1]
+}
+
+// parseForwarded interpreta o header Forwarded da RFC 7239:
+//
+//	Forwarded: for=192.0.2.60;proto=https;host=example.com, for="[2001:db8::1]:4711"
+func parseForwarded(values []string) []forwardedHop {
+	var hops []forwardedHop
+
+	for _, element := range headerList(values) {
+		var hop forwardedHop
+
+		for _, pair := range strings.Split(element, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok {
+				continue
+			}
+			value = strings.Trim(value, `"`)
+
+			switch strings.ToLower(key) {
+			case "for":
+				hop.forIP = forwardedNodeIP(value)
+			case "proto":
+				hop.proto = value
+			case "host":
+				hop.host = value
+			}
+		}
+
+		hops = append(hops, hop)
+	}
+
+	return hops
+}
+
+// forwardedNodeIP remove porta e colchetes de um nó do header Forwarded
+func forwardedNodeIP(node string) string {
+	if strings.HasPrefix(node, "[") {
+		if end := strings.Index(node, "]"); end > 0 {
+			return node[1:end]
+		}
+	}
+
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host
+	}
+	return node
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newProxyTestContext(t *testing.T, remoteAddr string, headers map[string]string) *TupaContext {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "http://api.local/users", nil)
+	req.RemoteAddr = remoteAddr
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	tc := newTestContext(httptest.NewRecorder(), req)
+	if err := tc.server.SetTrustedProxies("127.0.0.1", "10.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	return tc
+}
+
+func TestRealIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{
+			name:       "peer não confiável ignora headers",
+			remoteAddr: "203.0.113.9:5000",
+			headers:    map[string]string{"X-Forwarded-For": "1.1.1.1", "X-Real-IP": "2.2.2.2"},
+			want:       "203.0.113.9",
+		},
+		{
+			name:       "X-Forwarded-For pula proxies confiáveis",
+			remoteAddr: "127.0.0.1:5000",
+			headers:    map[string]string{"X-Forwarded-For": "6.6.6.6, 198.51.100.7, 10.0.0.2"},
+			want:       "198.51.100.7",
+		},
+		{
+			name:       "X-Real-IP quando não há X-Forwarded-For",
+			remoteAddr: "127.0.0.1:5000",
+			headers:    map[string]string{"X-Real-IP": "198.51.100.7"},
+			want:       "198.51.100.7",
+		},
+		{
+			name:       "Forwarded tem prioridade",
+			remoteAddr: "127.0.0.1:5000",
+			headers: map[string]string{
+				"Forwarded":       `for="[2001:db8::1]:4711";proto=https, for=10.0.0.3`,
+				"X-Forwarded-For": "198.51.100.7",
+			},
+			want: "2001:db8::1",
+		},
+		{
+			name:       "sem headers usa o peer",
+			remoteAddr: "127.0.0.1:5000",
+			want:       "127.0.0.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newProxyTestContext(t, tt.remoteAddr, tt.headers)
+
+			if got := tc.RealIP(); got != tt.want {
+				t.Errorf("esperava IP %s, recebeu %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSchemeAndHost(t *testing.T) {
+	t.Run("Testando headers de proxy confiável", func(t *testing.T) {
+		tc := newProxyTestContext(t, "127.0.0.1:5000", map[string]string{
+			"X-Forwarded-Proto": "https",
+			"X-Forwarded-Host":  "tupa.dev",
+		})
+
+		if !tc.IsTLS() || tc.Host() != "tupa.dev" {
+			t.Errorf("esperava https://tupa.dev, recebeu %s://%s", tc.Scheme(), tc.Host())
+		}
+
+		if got := tc.AbsoluteURL("/api/v1/auth/google/callback"); got != "https://tupa.dev/api/v1/auth/google/callback" {
+			t.Errorf("URL absoluta inesperada: %s", got)
+		}
+	})
+
+	t.Run("Testando headers de proxy não confiável", func(t *testing.T) {
+		tc := newProxyTestContext(t, "203.0.113.9:5000", map[string]string{
+			"X-Forwarded-Proto": "https",
+			"X-Forwarded-Host":  "evil.com",
+		})
+
+		if tc.IsTLS() || tc.Host() != "api.local" {
+			t.Errorf("esperava http://api.local, recebeu %s://%s", tc.Scheme(), tc.Host())
+		}
+	})
+
+	t.Run("Testando TLS direto", func(t *testing.T) {
+		tc := newProxyTestContext(t, "203.0.113.9:5000", nil)
+		tc.request.TLS = &tls.ConnectionState{}
+
+		if !tc.IsTLS() {
+			t.Errorf("esperava request TLS")
+		}
+	})
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"container/list"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RATE LIMIT
+
+type RateLimitAlgorithm int
+
+const (
+	// TokenBucket permite rajadas de até Burst requests e repõe Limit tokens por Window
+	TokenBucket RateLimitAlgorithm = iota
+	// SlidingWindow permite Limit requests em qualquer janela de Window, estimando a
+	// janela deslizante pelas contagens da janela atual e da anterior
+	SlidingWindow
+)
+
+// RateLimitMetaKey é a chave de RouteInfo.Meta com o RateLimit da rota
+//
+//	Meta: map[string]any{RateLimitMetaKey: RateLimit{Limit: 5, Window: time.Minute}},
+const RateLimitMetaKey = "rate_limit"
+
+type RateLimit struct {
+	Limit     int
+	Window    time.Duration
+	Algorithm RateLimitAlgorithm
+	// Burst é a capacidade do token bucket. Zero usa Limit
+	Burst int
+}
+
+func (l RateLimit) enabled() bool {
+	return l.Limit > 0 && l.Window > 0
+}
+
+// RateLimitResult é o estado do limite depois de uma request
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset é o tempo até o limite estar cheio de novo
+	Reset time.Duration
+	// RetryAfter é o tempo até a próxima request ser aceita, quando Allowed é false
+	RetryAfter time.Duration
+}
+
+// LimiterStore guarda o estado dos limites. Take conta uma request para key e deve ser
+// atômico, já que várias requests da mesma chave chegam ao mesmo tempo
+type LimiterStore interface {
+	Take(tc *TupaContext, key string, limit RateLimit) (RateLimitResult, error)
+}
+
+// RateLimitKeyFunc identifica quem está sendo limitado
+type RateLimitKeyFunc func(tc *TupaContext) string
+
+func KeyByIP(tc *TupaContext) string {
+	return "ip:" + tc.RealIP()
+}
+
+// KeyByUser limita pelo usuário autenticado e, sem usuário, pelo IP
+func KeyByUser(tc *TupaContext) string {
+	if tc.UserID() != "" {
+		return "user:" + tc.UserID()
+	}
+	return KeyByIP(tc)
+}
+
+// KeyByAPIKey limita pelo valor do header, como X-API-Key, e sem ele pelo IP
+func KeyByAPIKey(header string) RateLimitKeyFunc {
+	return func(tc *TupaContext) string {
+		if key := tc.request.Header.Get(header); key != "" {
+			return "key:" + key
+		}
+		return KeyByIP(tc)
+	}
+}
+
+// KeyByRoute usa um limite só para a rota, somando todos os clientes
+func KeyByRoute(tc *TupaContext) string {
+	if tc.route == nil {
+		return "route:" + tc.request.URL.Path
+	}
+	return "route:" + string(tc.route.Method) + " " + tc.route.Path
+}
+
+type RateLimitConfig struct {
+	// Limit vale para as rotas sem RateLimitMetaKey. Zero deixa essas rotas sem limite
+	Limit RateLimit
+	Store LimiterStore
+	// Key é KeyByIP por padrão
+	Key RateLimitKeyFunc
+	// FailClosed responde 503 quando o store falha. Por padrão a request passa e o erro é logado
+	FailClosed bool
+}
+
+// RateLimiter cria um middleware de rate limit. Cada rota com limite próprio tem contadores
+// separados; as outras dividem os contadores do limite padrão
+func RateLimiter(cfg RateLimitConfig) *NamedMiddleware {
+	if cfg.Store == nil {
+		cfg.Store = NewMemoryLimiterStore()
+	}
+	if cfg.Key == nil {
+		cfg.Key = KeyByIP
+	}
+
+	return Named("rate_limit", func(next APIFunc) APIFunc {
+		return func(tc *TupaContext) error {
+			limit, scope := cfg.Limit, "global"
+			if tc.route != nil {
+				if routeLimit, ok := tc.route.Meta[RateLimitMetaKey].(RateLimit); ok {
+					limit, scope = routeLimit, string(tc.route.Method)+" "+tc.route.Path
+				}
+			}
+
+			if !limit.enabled() {
+				return next(tc)
+			}
+
+			result, err := cfg.Store.Take(tc, scope+"|"+cfg.Key(tc), limit)
+			if err != nil {
+				if cfg.FailClosed {
+					return NewHTTPError(http.StatusServiceUnavailable, "").Wrap(err)
+				}
+				tc.Logger().Error("erro no rate limit, liberando a request", "error", err)
+				return next(tc)
+			}
+
+			setRateLimitHeaders(tc.response.Header(), limit, result)
+
+			if !result.Allowed {
+				return TooManyRequests("limite de requests excedido, tente novamente mais tarde", result.RetryAfter)
+			}
+			return next(tc)
+		}
+	}, After("request_id"))
+}
+
+// setRateLimitHeaders usa os headers RateLimit-* do draft da IETF, com tempos em segundos.
+// RateLimit-Limit é a cota de que Remaining é descontado, que no token bucket é o Burst; a
+// policy anuncia essa mesma cota no parâmetro burst quando ela difere de Limit
+func setRateLimitHeaders(header http.Header, limit RateLimit, result RateLimitResult) {
+	policy := strconv.Itoa(limit.Limit) + ";w=" + strconv.Itoa(ceilSeconds(limit.Window))
+	if result.Limit != limit.Limit {
+		policy += ";burst=" + strconv.Itoa(result.Limit)
+	}
+
+	header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+	header.Set("RateLimit-Policy", policy)
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// MemoryLimiterStore guarda os limites na memória do processo, então cada instância do
+// servidor tem seus próprios contadores. Chaves expiradas são removidas periodicamente e,
+// com MaxKeys chaves, a usada há mais tempo sai para dar lugar à nova
+type MemoryLimiterStore struct {
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	// MaxKeys limita a quantidade de chaves guardadas. Zero usa 100 mil
+	MaxKeys int
+	takes   int
+	now     func() time.Time
+}
+
+type limiterEntry struct {
+	key string
+
+	// token bucket
+	tokens float64
+	last   time.Time
+
+	// janela deslizante
+	windowStart time.Time
+	current     int
+	previous    int
+
+	expiresAt time.Time
+}
+
+func NewMemoryLimiterStore() *MemoryLimiterStore {
+	return &MemoryLimiterStore{lru: list.New(), entries: make(map[string]*list.Element), now: time.Now}
+}
+
+func (m *MemoryLimiterStore) Take(tc *TupaContext, key string, limit RateLimit) (RateLimitResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+
+	var entry *limiterEntry
+	if elem, ok := m.entries[key]; ok {
+		m.lru.MoveToFront(elem)
+		entry = elem.Value.(*limiterEntry)
+	} else {
+		m.evict()
+		entry = &limiterEntry{key: key}
+		m.entries[key] = m.lru.PushFront(entry)
+	}
+
+	if limit.Algorithm == SlidingWindow {
+		return entry.takeSlidingWindow(limit, now), nil
+	}
+	return entry.takeTokenBucket(limit, now), nil
+}
+
+// sweep remove as chaves expiradas. A varredura é O(n), então só roda a cada 1000 requests.
+// Chamado com o mutex travado, assim como evict e remove
+func (m *MemoryLimiterStore) sweep(now time.Time) {
+	m.takes++
+	if m.takes%1000 != 0 {
+		return
+	}
+
+	for elem := m.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if now.After(elem.Value.(*limiterEntry).expiresAt) {
+			m.remove(elem)
+		}
+		elem = next
+	}
+}
+
+// evict abre espaço para uma chave nova quando o store está cheio
+func (m *MemoryLimiterStore) evict() {
+	maxKeys := m.MaxKeys
+	if maxKeys <= 0 {
+		maxKeys = 100_000
+	}
+
+	// a chave usada há mais tempo sai e, se voltar, começa com o limite cheio
+	for m.lru.Len() >= maxKeys {
+		m.remove(m.lru.Back())
+	}
+}
+
+func (m *MemoryLimiterStore) remove(elem *list.Element) {
+	entry := m.lru.Remove(elem).(*limiterEntry)
+	delete(m.entries, entry.key)
+}
+
+func (e *limiterEntry) takeTokenBucket(limit RateLimit, now time.Time) RateLimitResult {
+	capacity := float64(limit.Burst)
+	if capacity <= 0 {
+		capacity = float64(limit.Limit)
+	}
+	perSecond := float64(limit.Limit) / limit.Window.Seconds()
+
+	if e.last.IsZero() {
+		e.tokens = capacity
+	} else {
+		e.tokens = math.Min(capacity, e.tokens+now.Sub(e.last).Seconds()*perSecond)
+	}
+	e.last = now
+
+	result := RateLimitResult{Limit: int(capacity)}
+	if e.tokens >= 1 {
+		e.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsDuration((1 - e.tokens) / perSecond)
+	}
+
+	result.Remaining = int(e.tokens)
+	result.Reset = secondsDuration((capacity - e.tokens) / perSecond)
+	e.expiresAt = now.Add(result.Reset)
+	return result
+}
+
+func (e *limiterEntry) takeSlidingWindow(limit RateLimit, now time.Time) RateLimitResult {
+	window := limit.Window
+	start := now.Truncate(window)
+
+	if !start.Equal(e.windowStart) {
+		if start.Sub(e.windowStart) == window {
+			e.previous = e.current
+		} else {
+			e.previous = 0
+		}
+		e.current = 0
+		e.windowStart = start
+	}
+
+	elapsed := now.Sub(start)
+	weight := 1 - float64(elapsed)/float64(window)
+	estimated := float64(e.previous)*weight + float64(e.current)
+
+	result := RateLimitResult{Limit: limit.Limit, Reset: window - elapsed}
+	if estimated+1 <= float64(limit.Limit) {
+		e.current++
+		estimated++
+		result.Allowed = true
+	} else {
+		result.RetryAfter = e.retryAfter(limit, elapsed)
+	}
+
+	result.Remaining = max(0, limit.Limit-int(math.Ceil(estimated)))
+	e.expiresAt = start.Add(2 * window)
+	return result
+}
+
+// retryAfter calcula quando o peso da janela anterior cai o suficiente para caber mais
+// uma request. Se a janela atual sozinha já está cheia, só na próxima janela
+func (e *limiterEntry) retryAfter(limit RateLimit, elapsed time.Duration) time.Duration {
+	free := float64(limit.Limit - 1 - e.current)
+	if e.previous > 0 && free >= 0 {
+		weight := free / float64(e.previous)
+		at := time.Duration((1 - weight) * float64(limit.Window))
+		if at > elapsed {
+			return at - elapsed
+		}
+	}
+	return limit.Window - elapsed
+}
+
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
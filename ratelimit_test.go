@@ -0,0 +1,195 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newTestLimiterStore() (*MemoryLimiterStore, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	store := NewMemoryLimiterStore()
+	store.now = clock.Now
+	return store, clock
+}
+
+func TestRateLimitAlgorithms(t *testing.T) {
+	t.Run("Testando token bucket com rajada e reposição", func(t *testing.T) {
+		store, clock := newTestLimiterStore()
+		limit := RateLimit{Limit: 60, Window: time.Minute, Burst: 3}
+
+		for i := 0; i < 3; i++ {
+			if result, _ := store.Take(nil, "k", limit); !result.Allowed {
+				t.Fatalf("request %d da rajada deveria passar", i+1)
+			}
+		}
+
+		result, _ := store.Take(nil, "k", limit)
+		if result.Allowed || result.RetryAfter != time.Second {
+			t.Errorf("esperava bloqueio com RetryAfter de 1s, recebeu %+v", result)
+		}
+
+		clock.now = clock.now.Add(time.Second)
+		if result, _ := store.Take(nil, "k", limit); !result.Allowed {
+			t.Errorf("um token deveria ter sido reposto depois de 1s")
+		}
+	})
+
+	t.Run("Testando janela deslizante", func(t *testing.T) {
+		store, clock := newTestLimiterStore()
+		limit := RateLimit{Limit: 4, Window: time.Minute, Algorithm: SlidingWindow}
+
+		for i := 0; i < 4; i++ {
+			store.Take(nil, "k", limit)
+		}
+		if result, _ := store.Take(nil, "k", limit); result.Allowed || result.Remaining != 0 {
+			t.Errorf("quinta request na janela deveria ser bloqueada: %+v", result)
+		}
+
+		// 15s na janela seguinte: a anterior ainda pesa 75%, ou seja 3 requests
+		clock.now = clock.now.Add(75 * time.Second)
+		result, _ := store.Take(nil, "k", limit)
+		if !result.Allowed {
+			t.Fatalf("deveria caber uma request com a janela anterior pesando 3")
+		}
+
+		result, _ = store.Take(nil, "k", limit)
+		if result.Allowed {
+			t.Errorf("a janela estimada está cheia, deveria bloquear")
+		}
+		// precisa que a anterior pese 2 (50%), o que acontece aos 30s da janela
+		if result.RetryAfter != 15*time.Second {
+			t.Errorf("RetryAfter esperado de 15s, recebeu %v", result.RetryAfter)
+		}
+	})
+
+	t.Run("Testando remoção de chaves", func(t *testing.T) {
+		store, clock := newTestLimiterStore()
+		store.MaxKeys = 10
+		limit := RateLimit{Limit: 1, Window: time.Second}
+
+		for i := 0; i < 50; i++ {
+			store.Take(nil, string(rune('a'+i)), limit)
+			clock.now = clock.now.Add(time.Millisecond)
+		}
+
+		if len(store.entries) > 10 {
+			t.Errorf("store deveria respeitar MaxKeys, tem %d chaves", len(store.entries))
+		}
+	})
+
+	t.Run("Testando que a chave usada há mais tempo sai primeiro", func(t *testing.T) {
+		store, _ := newTestLimiterStore()
+		store.MaxKeys = 2
+		limit := RateLimit{Limit: 1, Window: time.Minute}
+
+		store.Take(nil, "a", limit)
+		store.Take(nil, "b", limit)
+		// "a" bloqueada e usada de novo, então "b" é a que sai quando "c" chega
+		store.Take(nil, "a", limit)
+		store.Take(nil, "c", limit)
+
+		if _, ok := store.entries["b"]; ok {
+			t.Errorf("b deveria ter sido removida")
+		}
+		if result, _ := store.Take(nil, "a", limit); result.Allowed {
+			t.Errorf("a deveria continuar limitada depois da remoção de b")
+		}
+	})
+}
+
+func TestRateLimiter(t *testing.T) {
+	serve := func(handler http.HandlerFunc, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/upload", nil)
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	t.Run("Testando limite por rota com 429 e headers", func(t *testing.T) {
+		server := NewAPIServer(":6969")
+		server.UseGlobalMiddleware(RateLimiter(RateLimitConfig{}))
+		handler := server.MakeHTTPHandlerFuncHelper(RouteInfo{
+			Path:    "/upload",
+			Method:  MethodPost,
+			Handler: APIFunc(handleSendString),
+			Meta:    map[string]any{RateLimitMetaKey: RateLimit{Limit: 2, Window: time.Minute}},
+		})
+
+		w := serve(handler, "10.0.0.1")
+		if w.Code != http.StatusOK || w.Header().Get("RateLimit-Limit") != "2" || w.Header().Get("RateLimit-Remaining") != "1" {
+			t.Errorf("primeira request com headers errados: %d %v", w.Code, w.Header())
+		}
+		if w.Header().Get("RateLimit-Policy") != "2;w=60" {
+			t.Errorf("RateLimit-Policy errado: %q", w.Header().Get("RateLimit-Policy"))
+		}
+
+		serve(handler, "10.0.0.1")
+		w = serve(handler, "10.0.0.1")
+		if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "30" {
+			t.Errorf("esperava 429 com Retry-After 30, recebeu %d %q", w.Code, w.Header().Get("Retry-After"))
+		}
+
+		if w := serve(handler, "10.0.0.2"); w.Code != http.StatusOK {
+			t.Errorf("outro IP não deveria ser limitado, recebeu %d", w.Code)
+		}
+	})
+
+	t.Run("Testando headers com burst", func(t *testing.T) {
+		server := NewAPIServer(":6969")
+		server.UseGlobalMiddleware(RateLimiter(RateLimitConfig{}))
+		handler := server.MakeHTTPHandlerFuncHelper(RouteInfo{
+			Path:    "/upload",
+			Method:  MethodPost,
+			Handler: APIFunc(handleSendString),
+			Meta:    map[string]any{RateLimitMetaKey: RateLimit{Limit: 5, Window: time.Minute, Burst: 10}},
+		})
+
+		w := serve(handler, "10.0.0.1")
+		if w.Header().Get("RateLimit-Limit") != "10" || w.Header().Get("RateLimit-Remaining") != "9" {
+			t.Errorf("Limit e Remaining deveriam contar o burst: %v", w.Header())
+		}
+		if w.Header().Get("RateLimit-Policy") != "5;w=60;burst=10" {
+			t.Errorf("RateLimit-Policy errado: %q", w.Header().Get("RateLimit-Policy"))
+		}
+	})
+
+	t.Run("Testando rota sem limite e chave por usuário", func(t *testing.T) {
+		server := NewAPIServer(":6969")
+		server.UseGlobalMiddleware(MiddlewareFunc(func(next APIFunc) APIFunc {
+			return func(tc *TupaContext) error {
+				tc.SetUserID("victor")
+				return next(tc)
+			}
+		}), RateLimiter(RateLimitConfig{Key: KeyByUser}))
+
+		free := server.MakeHTTPHandlerFuncHelper(RouteInfo{Path: "/upload", Method: MethodPost, Handler: APIFunc(handleSendString)})
+		for i := 0; i < 10; i++ {
+			if w := serve(free, "10.0.0.1"); w.Code != http.StatusOK || w.Header().Get("RateLimit-Limit") != "" {
+				t.Fatalf("rota sem limite não deveria ser limitada: %d", w.Code)
+			}
+		}
+
+		limited := server.MakeHTTPHandlerFuncHelper(RouteInfo{
+			Path:    "/upload",
+			Method:  MethodPost,
+			Handler: APIFunc(handleSendString),
+			Meta:    map[string]any{RateLimitMetaKey: RateLimit{Limit: 1, Window: time.Minute}},
+		})
+		serve(limited, "10.0.0.1")
+		// mesmo usuário em outro IP divide o limite
+		if w := serve(limited, "10.0.0.9"); w.Code != http.StatusTooManyRequests {
+			t.Errorf("mesmo usuário deveria ser limitado em outro IP, recebeu %d", w.Code)
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+)
+
+// RECOVER
+
+type RecoverConfig struct {
+	// OnPanic é chamado depois do log, com o valor do panic e a stack trace, para
+	// enviar o erro a serviços como Sentry
+	OnPanic func(tc *TupaContext, recovered any, stack []byte)
+}
+
+// Recover cria um middleware que transforma panics em um 500 com APIError (se a resposta
+// ainda não foi enviada) e loga a stack trace. O servidor já roda Recover(RecoverConfig{})
+// por fora de todos os middlewares; use SetRecover para trocar a configuração
+func Recover(cfg RecoverConfig) *NamedMiddleware {
+	return Named("recover", func(next APIFunc) APIFunc {
+		return func(tc *TupaContext) (err error) {
+			defer func() {
+				r := recover()
+				if r == nil {
+					return
+				}
+
+				// ErrAbortHandler é o jeito do net/http de abortar a resposta sem log
+				if err, ok := r.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+					panic(r)
+				}
+
+				stack := debug.Stack()
+				tc.Logger().Error("panic recuperado", "panic", r, "stack", string(stack))
+
+				if cfg.OnPanic != nil {
+					cfg.OnPanic(tc, r, stack)
+				}
+
+				err = InternalError(panicError(r))
+			}()
+
+			return next(tc)
+		}
+	})
+}
+
+func panicError(r any) error {
+	if err, ok := r.(error); ok {
+		return fmt.Errorf("panic: %w", err)
+	}
+	return errors.New(fmt.Sprint("panic: ", r))
+}
+
+// SetRecover troca o middleware de recover que envolve todas as rotas, inclusive as com
+// ExcludeGlobal. Com nil os panics voltam a ser tratados pelo net/http. Precisa ser chamado
+// antes de iniciar o servidor
+func (a *APIServer) SetRecover(middleware Middleware) {
+	if a.started.Load() {
+		panic("SetRecover precisa ser chamado antes de iniciar o servidor")
+	}
+	a.recover = middleware
+
+	a.rebuildHandlers()
+}
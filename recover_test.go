@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecover(t *testing.T) {
+	panicHandler := func(tc *TupaContext) error {
+		var values []string
+		_ = values[3]
+		return nil
+	}
+
+	t.Run("Testando panic no handler virando 500", func(t *testing.T) {
+		var reported any
+		var stack []byte
+
+		server := NewAPIServer(":6969")
+		server.SetRecover(Recover(RecoverConfig{OnPanic: func(tc *TupaContext, recovered any, s []byte) {
+			reported = recovered
+			stack = s
+		}}))
+
+		req := httptest.NewRequest(http.MethodGet, "/panic", nil)
+		w := httptest.NewRecorder()
+		server.MakeHTTPHandlerFuncHelper(RouteInfo{Path: "/panic", Method: MethodGet, Handler: APIFunc(panicHandler)})(w, req)
+
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("status errado: recebeu %d queria %d", w.Code, http.StatusInternalServerError)
+		}
+
+		var body APIError
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil || body.Error == "" {
+			t.Errorf("esperava um APIError, recebeu %v", err)
+		}
+		if strings.Contains(body.Error, "index out of range") {
+			t.Errorf("detalhes do panic não deveriam ir para o cliente: %q", body.Error)
+		}
+
+		if reported == nil || !strings.Contains(string(stack), "recover_test.go") {
+			t.Errorf("OnPanic deveria receber o panic e a stack do handler")
+		}
+	})
+
+	t.Run("Testando panic em rota com ExcludeGlobal", func(t *testing.T) {
+		server := NewAPIServer(":6969")
+		server.UseGlobalMiddleware(MiddlewareFunc(MiddlewareContrA))
+
+		w := httptest.NewRecorder()
+		server.MakeHTTPHandlerFuncHelper(RouteInfo{
+			Path:          "/panic",
+			Method:        MethodGet,
+			ExcludeGlobal: true,
+			Handler:       APIFunc(panicHandler),
+		})(w, httptest.NewRequest(http.MethodGet, "/panic", nil))
+
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("recover deveria rodar mesmo sem os globais, recebeu %d", w.Code)
+		}
+	})
+
+	t.Run("Testando panic depois da resposta enviada", func(t *testing.T) {
+		server := NewAPIServer(":6969")
+
+		w := httptest.NewRecorder()
+		server.MakeHTTPHandlerFuncHelper(RouteInfo{
+			Path:   "/panic",
+			Method: MethodGet,
+			Handler: APIFunc(func(tc *TupaContext) error {
+				tc.SendString("parcial")
+				panic("depois de escrever")
+			}),
+		})(w, httptest.NewRequest(http.MethodGet, "/panic", nil))
+
+		if w.Code != http.StatusOK || w.Body.String() != "parcial" {
+			t.Errorf("resposta já enviada não deveria mudar: %d %q", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("Testando http.ErrAbortHandler", func(t *testing.T) {
+		for _, abort := range []error{http.ErrAbortHandler, fmt.Errorf("upload cancelado: %w", http.ErrAbortHandler)} {
+			server := NewAPIServer(":6969")
+			handler := server.MakeHTTPHandlerFuncHelper(RouteInfo{
+				Path:   "/abort",
+				Method: MethodGet,
+				Handler: APIFunc(func(tc *TupaContext) error {
+					panic(abort)
+				}),
+			})
+
+			func() {
+				defer func() {
+					if r := recover(); r != abort {
+						t.Errorf("esperava o panic de %v, recebeu %v", abort, r)
+					}
+				}()
+
+				handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/abort", nil))
+			}()
+		}
+	})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// REQUEST ID
+
+const DefaultRequestIDHeader = "X-Request-ID"
+
+type RequestIDConfig struct {
+	// Header lido da request e escrito na resposta. O padrão é X-Request-ID
+	Header string
+	// Generator cria IDs para requests sem um ID válido. O padrão é NewULID, que
+	// é ordenável por tempo; GenerateRandomString(32) também serve
+	Generator func() (string, error)
+	// IgnoreIncoming descarta o ID vindo do cliente, para APIs expostas sem um proxy confiável
+	IgnoreIncoming bool
+}
+
+// requestIDs recebidos só são aceitos com caracteres seguros para logs e headers
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// requestIDKey é a chave do request ID no context. TupaContext.Value responde a ela
+// direto do tc, então contexts derivados do tc também enxergam o ID
+type requestIDKey struct{}
+
+// RequestIDMiddleware aceita o X-Request-ID da request ou gera um novo, devolve o ID na
+// resposta e o registra no tc, nos logs e nos APIError. Chamadas feitas com HTTPClient e
+// um context derivado do tc levam o mesmo ID
+func RequestIDMiddleware(cfg RequestIDConfig) *NamedMiddleware {
+	if cfg.Header == "" {
+		cfg.Header = DefaultRequestIDHeader
+	}
+	if cfg.Generator == nil {
+		cfg.Generator = NewULID
+	}
+
+	// roda antes dos outros middlewares para que todos os logs tenham o ID
+	return Named("request_id", func(next APIFunc) APIFunc {
+		return func(tc *TupaContext) error {
+			id := tc.request.Header.Get(cfg.Header)
+			if cfg.IgnoreIncoming || !requestIDPattern.MatchString(id) {
+				var err error
+				if id, err = cfg.Generator(); err != nil {
+					return err
+				}
+			}
+
+			tc.SetRequestID(id)
+			tc.response.Header().Set(cfg.Header, id)
+
+			return next(tc)
+		}
+	}, Priority(-100))
+}
+
+// SetRequestID registra o ID de correlação da request, inclusive nos logs
+func (tc *TupaContext) SetRequestID(id string) {
+	tc.requestID = id
+
+	if tc.logger != nil {
+		tc.AddLogAttrs("request_id", id)
+	}
+}
+
+// RequestIDFromContext retorna o request ID de um context derivado do TupaContext
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestIDTransport adiciona o request ID do context da request nas chamadas externas
+type RequestIDTransport struct {
+	Header string
+	Base   http.RoundTripper
+}
+
+func (t *RequestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	header := t.Header
+	if header == "" {
+		header = DefaultRequestIDHeader
+	}
+
+	id := RequestIDFromContext(req.Context())
+	if id == "" || req.Header.Get(header) != "" {
+		return base.RoundTrip(req)
+	}
+
+	// RoundTrippers não devem alterar a request original
+	req = req.Clone(req.Context())
+	req.Header.Set(header, id)
+	return base.RoundTrip(req)
+}
+
+// HTTPClient é o client para chamadas externas feitas durante uma request. Usado com
+// http.NewRequestWithContext(tc, ...) ele propaga o request ID
+var HTTPClient = &http.Client{
+	Transport: &RequestIDTransport{},
+	Timeout:   30 * time.Second,
+}
+
+const crockfordBase32 = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewULID gera um ULID: 48 bits de timestamp em milissegundos e 80 bits aleatórios,
+// em 26 caracteres base32 de Crockford, ordenáveis pela hora de criação
+func NewULID() (string, error) {
+	var id [16]byte
+	binary.BigEndian.PutUint64(id[:8], uint64(time.Now().UnixMilli())<<16)
+	if _, err := rand.Read(id[6:]); err != nil {
+		return "", err
+	}
+
+	// 128 bits em 26 grupos de 5 bits, com 2 bits de padding à esquerda
+	hi := binary.BigEndian.Uint64(id[:8])
+	lo := binary.BigEndian.Uint64(id[8:])
+
+	var out [26]byte
+	for i := 25; i >= 0; i-- {
+		out[i] = crockfordBase32[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:]), nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	serve := func(req *http.Request, handler APIFunc) (*httptest.ResponseRecorder, *bytes.Buffer) {
+		var logs bytes.Buffer
+		server := NewAPIServer(":6969")
+		server.SetLogHandler(slog.NewJSONHandler(&logs, nil))
+		server.UseGlobalMiddleware(RequestIDMiddleware(RequestIDConfig{}))
+
+		w := httptest.NewRecorder()
+		server.MakeHTTPHandlerFuncHelper(RouteInfo{Path: "/id", Method: MethodGet, Handler: handler})(w, req)
+		return w, &logs
+	}
+
+	t.Run("Testando ID recebido do cliente", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/id", nil)
+		req.Header.Set("X-Request-ID", "abc-123")
+
+		var fromTc, fromCtx string
+		w, _ := serve(req, func(tc *TupaContext) error {
+			fromTc = tc.RequestID()
+			ctx, cancel := context.WithCancel(tc)
+			defer cancel()
+			fromCtx = RequestIDFromContext(ctx)
+			return nil
+		})
+
+		if fromTc != "abc-123" || fromCtx != "abc-123" {
+			t.Errorf("ID errado no tc (%q) ou no context (%q)", fromTc, fromCtx)
+		}
+		if got := w.Header().Get("X-Request-ID"); got != "abc-123" {
+			t.Errorf("ID errado na resposta: %q", got)
+		}
+	})
+
+	t.Run("Testando ID inválido substituído por um ULID", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/id", nil)
+		req.Header.Set("X-Request-ID", "quebra\nlinha")
+
+		w, _ := serve(req, handleSendString)
+
+		if got := w.Header().Get("X-Request-ID"); len(got) != 26 || strings.Contains(got, "\n") {
+			t.Errorf("esperava um ULID novo, recebeu %q", got)
+		}
+	})
+
+	t.Run("Testando ID nos logs e no APIError", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/id", nil)
+		req.Header.Set("X-Request-ID", "req-42")
+
+		w, logs := serve(req, func(tc *TupaContext) error {
+			tc.Logger().Info("dentro do handler")
+			return errors.New("falhou")
+		})
+
+		var body APIError
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil || body.RequestID != "req-42" {
+			t.Errorf("APIError sem o request ID: %+v %v", body, err)
+		}
+		if strings.Count(logs.String(), `"request_id":"req-42"`) < 2 {
+			t.Errorf("logs sem o request ID: %s", logs.String())
+		}
+	})
+
+	t.Run("Testando propagação em chamadas externas", func(t *testing.T) {
+		var received string
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = r.Header.Get("X-Request-ID")
+		}))
+		defer upstream.Close()
+
+		req := httptest.NewRequest(http.MethodGet, "/id", nil)
+		req.Header.Set("X-Request-ID", "req-externo")
+
+		serve(req, func(tc *TupaContext) error {
+			out, err := http.NewRequestWithContext(tc, http.MethodGet, upstream.URL, nil)
+			if err != nil {
+				return err
+			}
+
+			resp, err := HTTPClient.Do(out)
+			if err != nil {
+				return err
+			}
+			return resp.Body.Close()
+		})
+
+		if received != "req-externo" {
+			t.Errorf("chamada externa recebeu o ID %q", received)
+		}
+	})
+
+	t.Run("Testando ULIDs ordenáveis", func(t *testing.T) {
+		a, _ := NewULID()
+		b, _ := NewULID()
+
+		if len(a) != 26 || a[:10] > b[:10] {
+			t.Errorf("ULIDs inesperados: %s %s", a, b)
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+)
+
+// TupaResponseWriter envolve o http.ResponseWriter da request e registra o status,
+// a quantidade de bytes escritos e se os headers já foram enviados ao cliente
+type TupaResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	size        int64
+	committed   bool
+	beforeWrite []func()
+}
+
+func NewTupaResponseWriter(w http.ResponseWriter) *TupaResponseWriter {
+	return &TupaResponseWriter{ResponseWriter: w}
+}
+
+func (rw *TupaResponseWriter) reset(w http.ResponseWriter) {
+	rw.ResponseWriter = w
+	rw.status = 0
+	rw.size = 0
+	rw.committed = false
+	// zerando os hooks antes de truncar para não segurar closures da request anterior
+	clear(rw.beforeWrite)
+	rw.beforeWrite = rw.beforeWrite[:0]
+}
+
+// Status retorna o status enviado ao cliente, ou 0 se nada foi escrito ainda
+func (rw *TupaResponseWriter) Status() int {
+	return rw.status
+}
+
+// Size retorna a quantidade de bytes do body escritos até agora
+func (rw *TupaResponseWriter) Size() int64 {
+	return rw.size
+}
+
+// Committed informa se os headers já foram enviados. Depois disso não é possível
+// alterar status nem headers da resposta
+func (rw *TupaResponseWriter) Committed() bool {
+	return rw.committed
+}
+
+// Before registra uma função que roda imediatamente antes dos headers serem enviados,
+// útil para setar cookies ou headers que dependem do que o handler fez
+func (rw *TupaResponseWriter) Before(fn func()) {
+	rw.beforeWrite = append(rw.beforeWrite, fn)
+}
+
+func (rw *TupaResponseWriter) WriteHeader(status int) {
+	if rw.committed {
+		return
+	}
+
+	// respostas informativas (103 Early Hints) podem se repetir e não encerram os headers,
+	// então não rodam os hooks nem marcam o writer como commitado
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		rw.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	// hooks podem registrar outros hooks, por isso percorremos pelo índice
+	for i := 0; i < len(rw.beforeWrite); i++ {
+		rw.beforeWrite[i]()
+	}
+
+	rw.status = status
+	rw.committed = true
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *TupaResponseWriter) Write(b []byte) (int, error) {
+	if !rw.committed {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	n, err := rw.ResponseWriter.Write(b)
+	rw.size += int64(n)
+	return n, err
+}
+
+// Unwrap permite que http.ResponseController encontre o writer original
+func (rw *TupaResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+func (rw *TupaResponseWriter) Flush() {
+	if !rw.committed {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	http.NewResponseController(rw.ResponseWriter).Flush()
+}
+
+func (rw *TupaResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buf, err := http.NewResponseController(rw.ResponseWriter).Hijack()
+	if err == nil {
+		rw.committed = true
+	}
+	return conn, buf, err
+}
+
+func (rw *TupaResponseWriter) ReadFrom(r io.Reader) (int64, error) {
+	if !rw.committed {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	var n int64
+	var err error
+	if rf, ok := rw.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		// io.Copy com um writer sem ReaderFrom para não cair de volta neste método
+		n, err = io.Copy(struct{ io.Writer }{rw.ResponseWriter}, r)
+	}
+	rw.size += n
+	return n, err
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTupaResponseWriter(t *testing.T) {
+	t.Run("Testando status, tamanho e commit da resposta", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		rw := NewTupaResponseWriter(rec)
+
+		if rw.Committed() || rw.Status() != 0 {
+			t.Errorf("writer não deveria estar commitado antes de escrever")
+		}
+
+		rw.WriteHeader(http.StatusCreated)
+		rw.WriteHeader(http.StatusInternalServerError)
+		rw.Write([]byte("hello"))
+
+		if !rw.Committed() {
+			t.Errorf("writer deveria estar commitado")
+		}
+		if rw.Status() != http.StatusCreated || rec.Code != http.StatusCreated {
+			t.Errorf("status esperado %d, recebeu %d", http.StatusCreated, rw.Status())
+		}
+		if rw.Size() != 5 {
+			t.Errorf("tamanho esperado 5, recebeu %d", rw.Size())
+		}
+	})
+
+	t.Run("Testando hooks antes de escrever", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		rw := NewTupaResponseWriter(rec)
+
+		rw.Before(func() {
+			rw.Header().Set("X-Before", "ok")
+		})
+		rw.Write([]byte("hello"))
+
+		if got := rec.Header().Get("X-Before"); got != "ok" {
+			t.Errorf("header esperado 'ok', recebeu '%s'", got)
+		}
+	})
+
+	t.Run("Testando resposta informativa antes do status final", func(t *testing.T) {
+		rec := &informationalRecorder{ResponseRecorder: httptest.NewRecorder()}
+		rw := NewTupaResponseWriter(rec)
+
+		rw.Header().Set("Link", "</style.css>; rel=preload")
+		rw.WriteHeader(http.StatusEarlyHints)
+
+		if rw.Committed() || rw.Status() != 0 {
+			t.Errorf("103 não deveria commitar o writer")
+		}
+
+		rw.WriteHeader(http.StatusCreated)
+
+		if !rw.Committed() || rw.Status() != http.StatusCreated {
+			t.Errorf("status esperado %d, recebeu %d", http.StatusCreated, rw.Status())
+		}
+		if len(rec.statuses) != 2 || rec.statuses[0] != http.StatusEarlyHints || rec.statuses[1] != http.StatusCreated {
+			t.Errorf("status enviados errados: %v", rec.statuses)
+		}
+	})
+
+	t.Run("Testando que o writer preserva Flusher", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		var w http.ResponseWriter = NewTupaResponseWriter(rec)
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			t.Fatal("esperava que o writer implementasse http.Flusher")
+		}
+		flusher.Flush()
+
+		if !rec.Flushed {
+			t.Errorf("esperava que o recorder tivesse recebido o flush")
+		}
+	})
+}
+
+func TestErrorAfterCommittedResponse(t *testing.T) {
+	server := NewAPIServer(":8080")
+	handler := server.MakeHTTPHandlerFuncHelper(RouteInfo{
+		Path:   "/upload",
+		Method: MethodPost,
+		Handler: APIFunc(func(tc *TupaContext) error {
+			tc.SendString("Arquivo salvo com sucesso\n")
+			return errors.New("erro depois de escrever")
+		}),
+	})
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, "/upload", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("status esperado %d, recebeu %d", http.StatusOK, rec.Code)
+	}
+	if body := rec.Body.String(); strings.Contains(body, "Error") {
+		t.Errorf("body não deveria conter um segundo JSON de erro: %q", body)
+	}
+}
+
+// informationalRecorder registra todos os status enviados, já que o ResponseRecorder
+// guarda só o primeiro, mesmo quando é 1xx
+type informationalRecorder struct {
+	*httptest.ResponseRecorder
+	statuses []int
+}
+
+func (r *informationalRecorder) WriteHeader(status int) {
+	r.statuses = append(r.statuses, status)
+	if status >= 200 {
+		r.ResponseRecorder.WriteHeader(status)
+	}
+}
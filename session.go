@@ -0,0 +1,532 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// SESSÕES
+
+const (
+	DefaultSessionCookie          = "tupa_session"
+	DefaultSessionIdleTimeout     = 30 * time.Minute
+	DefaultSessionAbsoluteTimeout = 24 * time.Hour
+
+	// SessionUserIDKey é a chave da sessão com o ID do usuário logado,
+	// usada pelo SessionMiddleware para preencher tc.UserID
+	SessionUserIDKey = "user_id"
+)
+
+// SessionData é o que os stores persistem. Os valores ficam serializados em JSON
+// para que todos os stores devolvam os mesmos tipos
+type SessionData struct {
+	ID        string                     `json:"id"`
+	Values    map[string]json.RawMessage `json:"values"`
+	Flashes   []string                   `json:"flashes,omitempty"`
+	CreatedAt time.Time                  `json:"created_at"`
+	LastSeen  time.Time                  `json:"last_seen"`
+}
+
+func (d *SessionData) clone() *SessionData {
+	c := *d
+	c.Values = make(map[string]json.RawMessage, len(d.Values))
+	for k, v := range d.Values {
+		c.Values[k] = v
+	}
+	c.Flashes = append([]string(nil), d.Flashes...)
+	return &c
+}
+
+// SessionStore persiste as sessões. Load retorna nil, nil quando a sessão não existe
+type SessionStore interface {
+	Load(tc *TupaContext, id string) (*SessionData, error)
+	Save(tc *TupaContext, data *SessionData, ttl time.Duration) error
+	Delete(tc *TupaContext, id string) error
+}
+
+type SessionConfig struct {
+	Store           SessionStore
+	CookieName      string
+	IdleTimeout     time.Duration
+	AbsoluteTimeout time.Duration
+}
+
+type Session struct {
+	data      *SessionData
+	cfg       SessionConfig
+	isNew     bool
+	modified  bool
+	destroyed bool
+	saved     bool
+	staleIDs  []string
+}
+
+var sessionKey = NewKey[*Session]("tupa.session")
+
+// SessionMiddleware carrega a sessão da request a partir do cookie e salva antes
+// da resposta ser enviada. Sessões novas só são persistidas se algo for gravado nelas
+func SessionMiddleware(cfg SessionConfig) *NamedMiddleware {
+	if cfg.Store == nil {
+		cfg.Store = NewMemorySessionStore()
+	}
+	if cfg.CookieName == "" {
+		cfg.CookieName = DefaultSessionCookie
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = DefaultSessionIdleTimeout
+	}
+	if cfg.AbsoluteTimeout <= 0 {
+		cfg.AbsoluteTimeout = DefaultSessionAbsoluteTimeout
+	}
+
+	return Named("session", func(next APIFunc) APIFunc {
+		return func(tc *TupaContext) error {
+			session, err := loadSession(tc, cfg)
+			if err != nil {
+				return err
+			}
+
+			sessionKey.Set(tc, session)
+			if userID := session.GetString(SessionUserIDKey); userID != "" {
+				tc.SetUserID(userID)
+			}
+
+			tc.Writer().Before(func() {
+				if err := session.save(tc); err != nil {
+					tc.Logger().Error("erro ao salvar sessão", "error", err)
+				}
+			})
+
+			err = next(tc)
+
+			// se o handler não escreveu nada a resposta ainda aceita o Set-Cookie
+			if !tc.Writer().Committed() {
+				if saveErr := session.save(tc); saveErr != nil && err == nil {
+					return saveErr
+				}
+			}
+
+			return err
+		}
+	})
+}
+
+func loadSession(tc *TupaContext, cfg SessionConfig) (*Session, error) {
+	session := &Session{cfg: cfg}
+
+	if cookie, err := tc.Cookie(cfg.CookieName); err == nil && validSessionID(cookie.Value) {
+		data, err := cfg.Store.Load(tc, cookie.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		if data != nil && (now.Sub(data.LastSeen) > cfg.IdleTimeout || now.Sub(data.CreatedAt) > cfg.AbsoluteTimeout) {
+			session.staleIDs = append(session.staleIDs, data.ID)
+			data = nil
+		}
+
+		if data != nil {
+			session.data = data
+			return session, nil
+		}
+	}
+
+	data, err := newSessionData()
+	if err != nil {
+		return nil, err
+	}
+
+	session.data = data
+	session.isNew = true
+	return session, nil
+}
+
+func newSessionData() (*SessionData, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	return &SessionData{
+		ID:        id,
+		Values:    map[string]json.RawMessage{},
+		CreatedAt: now,
+		LastSeen:  now,
+	}, nil
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+var sessionIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{43}$`)
+
+func validSessionID(id string) bool {
+	return sessionIDPattern.MatchString(id)
+}
+
+// Session retorna a sessão da request. Sem o SessionMiddleware a sessão retornada
+// funciona normalmente, mas nada é persistido
+func (tc *TupaContext) Session() *Session {
+	if session, ok := sessionKey.Get(tc); ok {
+		return session
+	}
+
+	data, _ := newSessionData()
+	session := &Session{data: data, isNew: true}
+	sessionKey.Set(tc, session)
+	return session
+}
+
+func (s *Session) ID() string {
+	return s.data.ID
+}
+
+// Get lê o valor de key em dst, retornando false se a chave não existir
+func (s *Session) Get(key string, dst any) (bool, error) {
+	raw, ok := s.data.Values[key]
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(raw, dst)
+}
+
+func (s *Session) GetString(key string) string {
+	var value string
+	s.Get(key, &value)
+	return value
+}
+
+func (s *Session) Set(key string, value any) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	s.data.Values[key] = raw
+	s.modified = true
+	return nil
+}
+
+func (s *Session) Delete(key string) {
+	delete(s.data.Values, key)
+	s.modified = true
+}
+
+// AddFlash guarda uma mensagem que será lida uma única vez, normalmente na próxima request
+func (s *Session) AddFlash(message string) {
+	s.data.Flashes = append(s.data.Flashes, message)
+	s.modified = true
+}
+
+// Flashes retorna e remove as mensagens flash da sessão
+func (s *Session) Flashes() []string {
+	flashes := s.data.Flashes
+	if len(flashes) > 0 {
+		s.data.Flashes = nil
+		s.modified = true
+	}
+	return flashes
+}
+
+// Regenerate troca o ID da sessão mantendo os valores. Deve ser chamado no login
+// para evitar session fixation
+func (s *Session) Regenerate() error {
+	id, err := newSessionID()
+	if err != nil {
+		return err
+	}
+
+	if !s.isNew {
+		s.staleIDs = append(s.staleIDs, s.data.ID)
+	}
+
+	now := time.Now()
+	s.data.ID = id
+	s.data.CreatedAt = now
+	s.data.LastSeen = now
+	s.isNew = true
+	s.modified = true
+	return nil
+}
+
+// Destroy apaga a sessão do store e o cookie do navegador, usado no logout
+func (s *Session) Destroy() {
+	s.destroyed = true
+	s.modified = true
+}
+
+func (s *Session) save(tc *TupaContext) error {
+	if s.saved || s.cfg.Store == nil {
+		return nil
+	}
+	s.saved = true
+
+	for _, id := range s.staleIDs {
+		if err := s.cfg.Store.Delete(tc, id); err != nil {
+			return err
+		}
+	}
+
+	if s.destroyed {
+		tc.DeleteCookie(s.cfg.CookieName)
+		if s.isNew {
+			return nil
+		}
+		return s.cfg.Store.Delete(tc, s.data.ID)
+	}
+
+	// sessões novas e vazias não criam cookie
+	if s.isNew && !s.modified {
+		return nil
+	}
+
+	now := time.Now()
+	s.data.LastSeen = now
+
+	ttl := min(s.cfg.IdleTimeout, s.cfg.AbsoluteTimeout-now.Sub(s.data.CreatedAt))
+	if err := s.cfg.Store.Save(tc, s.data, ttl); err != nil {
+		return err
+	}
+
+	tc.SetCookie(&http.Cookie{
+		Name:   s.cfg.CookieName,
+		Value:  s.data.ID,
+		MaxAge: int(ttl.Seconds()),
+	})
+	return nil
+}
+
+// STORES
+
+// MemorySessionStore guarda as sessões na memória do processo. Não é compartilhado
+// entre instâncias e é perdido quando o servidor reinicia
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]memorySession
+	saves    int
+}
+
+type memorySession struct {
+	data      *SessionData
+	expiresAt time.Time
+}
+
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string]memorySession)}
+}
+
+func (m *MemorySessionStore) Load(tc *TupaContext, id string) (*SessionData, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+
+	if time.Now().After(session.expiresAt) {
+		delete(m.sessions, id)
+		return nil, nil
+	}
+
+	return session.data.clone(), nil
+}
+
+func (m *MemorySessionStore) Save(tc *TupaContext, data *SessionData, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions[data.ID] = memorySession{data: data.clone(), expiresAt: time.Now().Add(ttl)}
+
+	// limpando sessões expiradas de tempos em tempos
+	m.saves++
+	if m.saves%100 == 0 {
+		now := time.Now()
+		for id, session := range m.sessions {
+			if now.After(session.expiresAt) {
+				delete(m.sessions, id)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *MemorySessionStore) Delete(tc *TupaContext, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, id)
+	return nil
+}
+
+// FileSessionStore guarda cada sessão como um arquivo JSON em dir
+type FileSessionStore struct {
+	dir string
+}
+
+type fileSession struct {
+	Data      *SessionData `json:"data"`
+	ExpiresAt time.Time    `json:"expires_at"`
+}
+
+func NewFileSessionStore(dir string) (*FileSessionStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileSessionStore{dir: dir}, nil
+}
+
+func (f *FileSessionStore) path(id string) (string, error) {
+	if !validSessionID(id) {
+		return "", fmt.Errorf("ID de sessão inválido: %q", id)
+	}
+	return filepath.Join(f.dir, id+".json"), nil
+}
+
+func (f *FileSessionStore) Load(tc *TupaContext, id string) (*SessionData, error) {
+	path, err := f.path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var session fileSession
+	if err := json.Unmarshal(raw, &session); err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(session.ExpiresAt) {
+		os.Remove(path)
+		return nil, nil
+	}
+
+	return session.Data, nil
+}
+
+func (f *FileSessionStore) Save(tc *TupaContext, data *SessionData, ttl time.Duration) error {
+	path, err := f.path(data.ID)
+	if err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(fileSession{Data: data, ExpiresAt: time.Now().Add(ttl)})
+	if err != nil {
+		return err
+	}
+
+	// escrevendo em um arquivo temporário e renomeando para não deixar sessões pela metade
+	tmp, err := os.CreateTemp(f.dir, data.ID+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (f *FileSessionStore) Delete(tc *TupaContext, id string) error {
+	path, err := f.path(id)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// CookieSessionStore guarda a sessão inteira em um cookie criptografado, sem estado no servidor.
+// Os cookies têm limite de ~4KB, então serve para sessões pequenas
+type CookieSessionStore struct {
+	keyring    *CookieKeyring
+	cookieName string
+}
+
+const maxSessionCookieSize = 4096
+
+func NewCookieSessionStore(keyring *CookieKeyring) *CookieSessionStore {
+	return &CookieSessionStore{keyring: keyring, cookieName: DefaultSessionCookie + "_data"}
+}
+
+func (c *CookieSessionStore) Load(tc *TupaContext, id string) (*SessionData, error) {
+	cookie, err := tc.Cookie(c.cookieName)
+	if err != nil {
+		return nil, nil
+	}
+
+	raw, err := c.keyring.Decrypt(c.cookieName, cookie.Value)
+	if err != nil {
+		return nil, nil
+	}
+
+	var session fileSession
+	if err := json.Unmarshal([]byte(raw), &session); err != nil {
+		return nil, nil
+	}
+
+	// o cookie de dados precisa pertencer à sessão do cookie de ID
+	if session.Data == nil || session.Data.ID != id || time.Now().After(session.ExpiresAt) {
+		return nil, nil
+	}
+
+	return session.Data, nil
+}
+
+func (c *CookieSessionStore) Save(tc *TupaContext, data *SessionData, ttl time.Duration) error {
+	raw, err := json.Marshal(fileSession{Data: data, ExpiresAt: time.Now().Add(ttl)})
+	if err != nil {
+		return err
+	}
+
+	value, err := c.keyring.Encrypt(c.cookieName, string(raw))
+	if err != nil {
+		return err
+	}
+
+	if len(value) > maxSessionCookieSize {
+		return fmt.Errorf("sessão com %d bytes excede o limite de um cookie", len(value))
+	}
+
+	tc.SetCookie(&http.Cookie{Name: c.cookieName, Value: value, MaxAge: int(ttl.Seconds())})
+	return nil
+}
+
+// Delete remove o cookie de dados. Em uma regeneração o Save seguinte escreve o cookie
+// novo depois deste, e o navegador fica com o último
+func (c *CookieSessionStore) Delete(tc *TupaContext, id string) error {
+	tc.DeleteCookie(c.cookieName)
+	return nil
+}
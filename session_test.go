@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// sessionTestClient guarda os cookies entre requests como um navegador simples
+type sessionTestClient struct {
+	handler http.HandlerFunc
+	cookies map[string]*http.Cookie
+}
+
+func newSessionTestClient(cfg SessionConfig, handler APIFunc) *sessionTestClient {
+	server := NewAPIServer(":8080")
+	return &sessionTestClient{
+		handler: server.MakeHTTPHandlerFuncHelper(RouteInfo{
+			Method:      MethodGet,
+			Handler:     handler,
+			Middlewares: []Middleware{SessionMiddleware(cfg)},
+		}),
+		cookies: map[string]*http.Cookie{},
+	}
+}
+
+func (c *sessionTestClient) do() *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, cookie := range c.cookies {
+		req.AddCookie(cookie)
+	}
+
+	w := httptest.NewRecorder()
+	c.handler(w, req)
+
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.MaxAge < 0 {
+			delete(c.cookies, cookie.Name)
+			continue
+		}
+		c.cookies[cookie.Name] = cookie
+	}
+	return w
+}
+
+func testSessionStore(t *testing.T, store SessionStore) {
+	t.Helper()
+	step := 0
+
+	client := newSessionTestClient(SessionConfig{Store: store}, func(tc *TupaContext) error {
+		session := tc.Session()
+
+		switch step {
+		case 0:
+			session.Set("user", "victor")
+			session.AddFlash("bem vindo")
+		case 1:
+			if got := session.GetString("user"); got != "victor" {
+				t.Errorf("esperava usuário 'victor', recebeu '%s'", got)
+			}
+			if flashes := session.Flashes(); len(flashes) != 1 || flashes[0] != "bem vindo" {
+				t.Errorf("esperava flash 'bem vindo', recebeu %v", flashes)
+			}
+		case 2:
+			if flashes := session.Flashes(); len(flashes) != 0 {
+				t.Errorf("flash deveria ter sido consumido, recebeu %v", flashes)
+			}
+		}
+
+		return tc.SendString("ok")
+	})
+
+	for step = 0; step < 3; step++ {
+		client.do()
+	}
+}
+
+func TestSessionStores(t *testing.T) {
+	t.Run("Testando MemorySessionStore", func(t *testing.T) {
+		testSessionStore(t, NewMemorySessionStore())
+	})
+
+	t.Run("Testando FileSessionStore", func(t *testing.T) {
+		store, err := NewFileSessionStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		testSessionStore(t, store)
+	})
+
+	t.Run("Testando CookieSessionStore", func(t *testing.T) {
+		keyring, err := NewCookieKeyring(bytes.Repeat([]byte("s"), 32))
+		if err != nil {
+			t.Fatal(err)
+		}
+		testSessionStore(t, NewCookieSessionStore(keyring))
+	})
+}
+
+func TestSessionLifecycle(t *testing.T) {
+	t.Run("Testando sessão vazia não cria cookie", func(t *testing.T) {
+		client := newSessionTestClient(SessionConfig{}, func(tc *TupaContext) error {
+			tc.Session()
+			return tc.SendString("ok")
+		})
+
+		if cookies := client.do().Result().Cookies(); len(cookies) != 0 {
+			t.Errorf("não esperava cookies, recebeu %v", cookies)
+		}
+	})
+
+	t.Run("Testando Regenerate no login", func(t *testing.T) {
+		store := NewMemorySessionStore()
+		login := false
+
+		client := newSessionTestClient(SessionConfig{Store: store}, func(tc *TupaContext) error {
+			session := tc.Session()
+			if login {
+				session.Regenerate()
+				session.Set("user", "victor")
+			} else {
+				session.Set("visitas", 1)
+			}
+			return tc.SendString("ok")
+		})
+
+		client.do()
+		oldID := client.cookies[DefaultSessionCookie].Value
+
+		login = true
+		client.do()
+		newID := client.cookies[DefaultSessionCookie].Value
+
+		if oldID == newID {
+			t.Fatal("esperava um novo ID de sessão após o login")
+		}
+		if data, _ := store.Load(nil, oldID); data != nil {
+			t.Errorf("sessão antiga deveria ter sido removida do store")
+		}
+		if data, _ := store.Load(nil, newID); data == nil {
+			t.Errorf("sessão nova deveria estar no store")
+		}
+	})
+
+	t.Run("Testando expiração por inatividade", func(t *testing.T) {
+		step := 0
+		client := newSessionTestClient(SessionConfig{IdleTimeout: 20 * time.Millisecond}, func(tc *TupaContext) error {
+			session := tc.Session()
+			if step == 0 {
+				session.Set("user", "victor")
+			} else if got := session.GetString("user"); got != "" {
+				t.Errorf("sessão deveria ter expirado, recebeu usuário '%s'", got)
+			}
+			return tc.SendString("ok")
+		})
+
+		client.do()
+		time.Sleep(40 * time.Millisecond)
+		step = 1
+		client.do()
+	})
+
+	t.Run("Testando Destroy", func(t *testing.T) {
+		logout := false
+		client := newSessionTestClient(SessionConfig{}, func(tc *TupaContext) error {
+			if logout {
+				tc.Session().Destroy()
+			} else {
+				tc.Session().Set("user", "victor")
+			}
+			return tc.SendString("ok")
+		})
+
+		client.do()
+		logout = true
+		client.do()
+
+		if _, ok := client.cookies[DefaultSessionCookie]; ok {
+			t.Errorf("cookie de sessão deveria ter sido removido")
+		}
+	})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// STORE DE VALORES POR REQUEST
+
+// Key identifica um valor tipado guardado no TupaContext. Cada chamada de NewKey cria
+// uma chave diferente, mesmo com o mesmo nome, então pacotes distintos não colidem
+type Key[T any] struct {
+	name string
+}
+
+func NewKey[T any](name string) *Key[T] {
+	return &Key[T]{name: name}
+}
+
+// Get retorna o valor da chave e se ele estava presente na request
+func (k *Key[T]) Get(tc *TupaContext) (T, bool) {
+	v, ok := tc.Get(k)
+	if !ok {
+		var zero T
+		return zero, false
+	}
+
+	typed, ok := v.(T)
+	return typed, ok
+}
+
+// GetOr retorna o valor da chave ou fallback caso ela não tenha sido setada
+func (k *Key[T]) GetOr(tc *TupaContext, fallback T) T {
+	if v, ok := k.Get(tc); ok {
+		return v
+	}
+	return fallback
+}
+
+func (k *Key[T]) Set(tc *TupaContext, value T) {
+	tc.Set(k, value)
+}
+
+func (k *Key[T]) Delete(tc *TupaContext) {
+	tc.Delete(k)
+}
+
+func (k *Key[T]) String() string {
+	return fmt.Sprintf("%s (%s)", k.name, reflect.TypeOf((*T)(nil)).Elem())
+}
+
+// Set guarda um valor na request. A chave pode ser uma string ou uma *Key[T];
+// prefira Key[T] para evitar colisões e type assertions sem checagem
+func (tc *TupaContext) Set(key any, value any) {
+	tc.storeMu.Lock()
+	defer tc.storeMu.Unlock()
+
+	if tc.store == nil {
+		tc.store = make(map[any]any)
+	}
+	tc.store[key] = value
+}
+
+func (tc *TupaContext) Get(key any) (any, bool) {
+	tc.storeMu.RLock()
+	defer tc.storeMu.RUnlock()
+
+	v, ok := tc.store[key]
+	return v, ok
+}
+
+func (tc *TupaContext) Delete(key any) {
+	tc.storeMu.Lock()
+	defer tc.storeMu.Unlock()
+
+	delete(tc.store, key)
+}
+
+// Values retorna uma cópia dos valores da request com as chaves formatadas, para debug
+func (tc *TupaContext) Values() map[string]any {
+	tc.storeMu.RLock()
+	defer tc.storeMu.RUnlock()
+
+	values := make(map[string]any, len(tc.store))
+	for k, v := range tc.store {
+		values[fmt.Sprint(k)] = v
+	}
+	return values
+}